@@ -17,6 +17,7 @@
 package main
 
 import (
+	"errors"
 	"io/ioutil"
 
 	yaml "gopkg.in/yaml.v2"
@@ -31,6 +32,8 @@ type Config struct {
 	FrameInput   string `yaml:"frame-input"`
 	OutputDir    string `yaml:"output-dir"`
 	MinDiskSpace uint64 `yaml:"min-disk-space"`
+	FlushSecs    int    `yaml:"flush-secs"`
+	Storage      StorageConfig
 	Recorder     recorder.RecorderConfig
 	Motion       motion.MotionConfig
 	Turret       TurretConfig
@@ -57,6 +60,13 @@ type uploaderConfig struct {
 }
 
 func (conf *Config) Validate() error {
+	if conf.FlushSecs < 0 {
+		return errors.New("flush-secs can not be negative")
+	}
+	if err := conf.Storage.validate(); err != nil {
+		return err
+	}
+
 	if err := conf.Recorder.Validate(); err != nil {
 		return err
 	}
@@ -75,9 +85,13 @@ var defaultConfig = Config{
 	FrameInput:   "/var/run/lepton-frames",
 	OutputDir:    "/var/spool/cptv",
 	MinDiskSpace: 200,
-	Recorder:     recorder.DefaultRecorderConfig(),
-	Motion:       motion.DefaultMotionConfig(),
-	Throttler:    throttle.DefaultThrottlerConfig(),
+	FlushSecs:    10,
+	Storage: StorageConfig{
+		Evict: evictOldest,
+	},
+	Recorder:  recorder.DefaultRecorderConfig(),
+	Motion:    motion.DefaultMotionConfig(),
+	Throttler: throttle.DefaultThrottlerConfig(),
 	Turret: TurretConfig{
 		Active: false,
 		PID:    []float64{0.05, 0, 0},
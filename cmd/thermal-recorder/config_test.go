@@ -23,6 +23,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -31,7 +32,6 @@ import (
 	"github.com/TheCacophonyProject/thermal-recorder/motion"
 	"github.com/TheCacophonyProject/thermal-recorder/recorder"
 	"github.com/TheCacophonyProject/thermal-recorder/throttle"
-	"github.com/TheCacophonyProject/window"
 )
 
 func TestAllDefaults(t *testing.T) {
@@ -44,12 +44,17 @@ func TestAllDefaults(t *testing.T) {
 		FrameInput:   "/var/run/lepton-frames",
 		OutputDir:    "/var/spool/cptv",
 		MinDiskSpace: 200,
+		FlushSecs:    10,
+		Storage: StorageConfig{
+			Evict: "oldest",
+		},
 		Recorder: recorder.RecorderConfig{
 			MinSecs:     10,
 			MaxSecs:     600,
 			PreviewSecs: 3,
 		},
 		Motion: motion.MotionConfig{
+			Detector:        "frame-diff",
 			TempThresh:      2900,
 			DeltaThresh:     50,
 			CountThresh:     3,
@@ -57,8 +62,44 @@ func TestAllDefaults(t *testing.T) {
 			UseOneDiffOnly:  true,
 			Verbose:         false,
 			TriggerFrames:   2,
+			TriggerWindow:   0,
+			StartScore:      0,
+			ContinueScore:   0,
 			WarmerOnly:      true,
 			EdgePixels:      1,
+
+			TrackMaxDistance:     10,
+			TrackMaxMissedFrames: 9,
+			TrackMaxPath:         270,
+
+			BackgroundLearningRate:  0.01,
+			BackgroundSigma:         4,
+			BackgroundMaxFreezeSecs: 120,
+
+			Calibration: motion.Calibration{
+				CountsPerDegree:   30,
+				ReferenceCounts:   3000,
+				ReferenceTempC:    10,
+				ReferenceFPATempC: 25,
+			},
+
+			AdaptiveDelta:            false,
+			NoiseMultiplier:          6,
+			DeltaThreshMin:           20,
+			DeltaThreshMax:           100,
+			ObjectMinArea:            0,
+			ObjectMaxArea:            0,
+			ObjectMaxAspectRatio:     0,
+			ObjectMinDisplacement:    0,
+			ObjectDisplacementFrames: 18,
+			SceneChangeFraction:      0,
+			SceneChangeAction:        "suppress",
+			BadPixelDetection:        false,
+			BadPixelPeriod:           time.Hour,
+			BadPixelFile:             "/var/lib/thermal-recorder/bad-pixels.json",
+			FFCPeriod:                10 * time.Second,
+			FFCCompensation:          false,
+			FFCSettle:                time.Second,
 		},
 		Throttler: throttle.ThrottlerConfig{
 			ApplyThrottling: true,
@@ -108,22 +149,78 @@ func TestAllSet(t *testing.T) {
 frame-input: "/some/sock"
 output-dir: "/some/where"
 min-disk-space: 321
+flush-secs: 5
+storage:
+    max-size-mb: 1000
+    max-files: 500
+    evict: lowest-score
 recorder:
     min-secs: 2
     max-secs: 10
     preview-secs: 5
+    merge-gap-secs: 4
+    split-long-recordings: true
     window-start: 17:10
     window-end: 07:20
+    latitude: -43.5
+    longitude: 172.6
+    altitude: 30.5
+    accuracy: 10
 motion:
+    detector: frame-diff
     temp-thresh: 2000
     delta-thresh: 20
     count-thresh: 1
     frame-compare-gap: 90
     one-diff-only: false
     trigger-frames: 1
+    trigger-window: 4
+    start-score: 12
+    continue-score: 4.5
     verbose: true
     edge-pixels: 3
     warmer-only: false
+    track-max-distance: 6.5
+    track-max-missed-frames: 4
+    track-max-path: 50
+    background-learning-rate: 0.05
+    background-sigma: 3
+    background-max-freeze-secs: 60
+    temp-thresh-c: 12.5
+    calibration:
+      counts-per-degree: 40
+      reference-counts: 3100
+      reference-temp-c: 15
+      reference-fpa-temp-c: 30
+      fpa-counts-per-degree: -2.5
+    adaptive-delta: true
+    noise-multiplier: 4.5
+    delta-thresh-min: 15
+    delta-thresh-max: 80
+    object-min-area: 4
+    object-max-area: 400
+    object-max-aspect-ratio: 5
+    object-min-displacement: 3.5
+    object-displacement-frames: 12
+    scene-change-fraction: 0.4
+    scene-change-action: subtract
+    bad-pixel-detection: true
+    bad-pixel-period: 30m
+    bad-pixel-file: /some/bad-pixels.json
+    ffc-period: 8s
+    ffc-compensation: true
+    ffc-settle: 1500ms
+    masks:
+      - name: fence-post
+        rect: [10, 20, 12, 40]
+      - name: field
+        mode: include
+        polygon: [[0, 30], [159, 30], [159, 119], [0, 119]]
+    zones:
+      - name: far-field
+        rect: [0, 30, 159, 50]
+        delta-thresh: 30
+        count-thresh: 2
 throttler:
     apply-throttling: false
     throttle-after-secs: 650
@@ -166,14 +263,27 @@ device-name: "aDeviceName"
 		FrameInput:   "/some/sock",
 		OutputDir:    "/some/where",
 		MinDiskSpace: 321,
+		FlushSecs:    5,
+		Storage: StorageConfig{
+			MaxSizeMB: 1000,
+			MaxFiles:  500,
+			Evict:     "lowest-score",
+		},
 		Recorder: recorder.RecorderConfig{
-			MinSecs:     2,
-			MaxSecs:     10,
-			PreviewSecs: 5,
-			WindowStart: *window.NewTimeOfDay("17:10"),
-			WindowEnd:   *window.NewTimeOfDay("07:20"),
+			MinSecs:             2,
+			MaxSecs:             10,
+			PreviewSecs:         5,
+			MergeGapSecs:        4,
+			SplitLongRecordings: true,
+			WindowStart:         *recorder.NewWindowTime("17:10"),
+			WindowEnd:           *recorder.NewWindowTime("07:20"),
+			Latitude:            -43.5,
+			Longitude:           172.6,
+			Altitude:            30.5,
+			Accuracy:            10,
 		},
 		Motion: motion.MotionConfig{
+			Detector:        "frame-diff",
 			TempThresh:      2000,
 			DeltaThresh:     20,
 			CountThresh:     1,
@@ -181,8 +291,66 @@ device-name: "aDeviceName"
 			UseOneDiffOnly:  false,
 			Verbose:         true,
 			TriggerFrames:   1,
+			TriggerWindow:   4,
+			StartScore:      12,
+			ContinueScore:   4.5,
 			WarmerOnly:      false,
 			EdgePixels:      3,
+
+			TrackMaxDistance:     6.5,
+			TrackMaxMissedFrames: 4,
+			TrackMaxPath:         50,
+
+			BackgroundLearningRate:  0.05,
+			BackgroundSigma:         3,
+			BackgroundMaxFreezeSecs: 60,
+
+			TempThreshC: floatPtr(12.5),
+			Calibration: motion.Calibration{
+				CountsPerDegree:    40,
+				ReferenceCounts:    3100,
+				ReferenceTempC:     15,
+				ReferenceFPATempC:  30,
+				FPACountsPerDegree: -2.5,
+			},
+
+			AdaptiveDelta:            true,
+			NoiseMultiplier:          4.5,
+			DeltaThreshMin:           15,
+			DeltaThreshMax:           80,
+			ObjectMinArea:            4,
+			ObjectMaxArea:            400,
+			ObjectMaxAspectRatio:     5,
+			ObjectMinDisplacement:    3.5,
+			ObjectDisplacementFrames: 12,
+			SceneChangeFraction:      0.4,
+			SceneChangeAction:        "subtract",
+			BadPixelDetection:        true,
+			BadPixelPeriod:           30 * time.Minute,
+			BadPixelFile:             "/some/bad-pixels.json",
+			FFCPeriod:                8 * time.Second,
+			FFCCompensation:          true,
+			FFCSettle:                1500 * time.Millisecond,
+
+			Masks: []motion.MaskConfig{
+				{
+					Name:  "fence-post",
+					Shape: motion.Shape{Rect: []int{10, 20, 12, 40}},
+				},
+				{
+					Name:  "field",
+					Mode:  "include",
+					Shape: motion.Shape{Polygon: [][]int{{0, 30}, {159, 30}, {159, 119}, {0, 119}}},
+				},
+			},
+			Zones: []motion.ZoneConfig{
+				{
+					Name:        "far-field",
+					Shape:       motion.Shape{Rect: []int{0, 30, 159, 50}},
+					DeltaThresh: 30,
+					CountThresh: 2,
+				},
+			},
 		},
 		Throttler: throttle.ThrottlerConfig{
 			ApplyThrottling: false,
@@ -212,6 +380,10 @@ device-name: "aDeviceName"
 	}, *conf)
 }
 
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
 func GetDefaultConfig() []byte {
 	dir := GetBaseDir()
 	config_file := strings.Replace(dir, "cmd/thermal-recorder", "_release/thermal-recorder.yaml", 1)
@@ -254,3 +426,141 @@ recorder:
 	assert.Nil(t, conf)
 	assert.EqualError(t, err, "max-secs should be larger than min-secs")
 }
+
+func TestUnknownDetectorStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+motion:
+  detector: magic
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, `unknown motion detector "magic" (available: [background frame-diff])`)
+}
+
+func TestInvalidMaskStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+motion:
+  masks:
+    - name: road
+      rect: [10, 10, 5, 20]
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, `mask "road": rect left/top must not be after right/bottom`)
+}
+
+func TestAdaptiveDeltaWithCelsiusDeltaStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+motion:
+  adaptive-delta: true
+  delta-thresh-c: 1.5
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, "adaptive-delta can't be used with delta-thresh-c")
+}
+
+func TestTriggerWindowSmallerThanTriggerFramesStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+motion:
+  trigger-frames: 3
+  trigger-window: 2
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, "trigger-window must be at least trigger-frames")
+}
+
+func TestInvalidObjectFilterStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+motion:
+  object-min-area: 20
+  object-max-area: 10
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, "object-max-area should be larger than object-min-area")
+}
+
+func TestInvalidSceneChangeActionStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+motion:
+  scene-change-fraction: 0.3
+  scene-change-action: ignore
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, `scene-change-action must be "suppress" or "subtract"`)
+}
+
+func TestContinueScoreLargerThanStartScoreStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+motion:
+  start-score: 10
+  continue-score: 20
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, "continue-score should not be larger than start-score")
+}
+
+func TestContinueScoreWithoutStartScoreStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+motion:
+  continue-score: 5
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, "continue-score can only be used with start-score")
+}
+
+func TestRecordingSchedule(t *testing.T) {
+	configStr := []byte(`
+recorder:
+  schedule:
+    - days: [weekdays]
+      start: 05:00
+      end: 08:00
+    - days: [sat, sun]
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	require.NoError(t, err)
+	assert.Equal(t, []recorder.ScheduleEntry{
+		{
+			Days:  []string{"weekdays"},
+			Start: *recorder.NewWindowTime("05:00"),
+			End:   *recorder.NewWindowTime("08:00"),
+		},
+		{Days: []string{"sat", "sun"}},
+	}, conf.Recorder.Schedule)
+}
+
+func TestInvalidScheduleStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+recorder:
+  schedule:
+    - days: [caturday]
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, `unknown schedule day "caturday"`)
+}
+
+func TestNegativeFlushSecsStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+flush-secs: -1
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, "flush-secs can not be negative")
+}
+
+func TestUnknownEvictPolicyStopsConfigParsing(t *testing.T) {
+	configStr := []byte(`
+storage:
+  evict: newest
+`)
+	conf, err := ParseConfig(configStr, []byte(""))
+	assert.Nil(t, conf)
+	assert.EqualError(t, err, `unknown storage evict policy "newest"`)
+}
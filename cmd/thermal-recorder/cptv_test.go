@@ -183,7 +183,10 @@ func BenchmarkMotionDetection(b *testing.B) {
 
 	recorder := new(recorder.NoWriteRecorder)
 
-	processor := motion.NewMotionProcessor(&config.Motion, &config.Recorder, nil, recorder)
+	processor, err := motion.NewMotionProcessor(&config.Motion, &config.Recorder, nil, recorder)
+	if err != nil {
+		b.Fatal(err)
+	}
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -17,97 +17,293 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sync"
 	"syscall"
 	"time"
 
 	cptv "github.com/TheCacophonyProject/go-cptv"
 	"github.com/TheCacophonyProject/lepton3"
 	yaml "gopkg.in/yaml.v2"
+
+	"github.com/TheCacophonyProject/thermal-recorder/recorder"
 )
 
+var errNotRecording = errors.New("no recording in progress")
+
+// CPTV header strings can't be longer than this.
+const maxHeaderString = 255
+
+// The motion settings which are kept in the CPTV header when all of them
+// don't fit.  The full settings are always in the metadata file.
+var headerMotionKeys = []string{
+	"detector",
+	"temp-thresh",
+	"delta-thresh",
+	"count-thresh",
+	"frame-compare-gap",
+	"one-diff-only",
+	"trigger-frames",
+	"warmer-only",
+	"edge-pixels",
+}
+
 func NewCPTVFileRecorder(config *Config) *CPTVFileRecorder {
+	fw := new(CPTVFileRecorder)
+	fw.configure(config)
+	return fw
+}
+
+// Configure changes the settings used for the recordings which are
+// started from now on.
+func (fw *CPTVFileRecorder) Configure(config *Config) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.configure(config)
+}
+
+func (fw *CPTVFileRecorder) configure(config *Config) {
 	motionYAML, err := yaml.Marshal(config.Motion)
 	if err != nil {
 		panic(fmt.Sprintf("failed to convert motion config to YAML: %v", err))
 	}
-	return &CPTVFileRecorder{
-		outputDir: config.OutputDir,
-		header: cptv.Header{
-			DeviceName:   config.DeviceName,
-			PreviewSecs:  config.Recorder.PreviewSecs,
-			MotionConfig: string(motionYAML),
-		},
-		minDiskSpace: config.MinDiskSpace,
+	headerYAML, err := headerMotionConfig(motionYAML)
+	if err != nil {
+		panic(fmt.Sprintf("failed to shorten motion config: %v", err))
+	}
+	fw.outputDir = config.OutputDir
+	fw.header = cptv.Header{
+		DeviceName:   config.DeviceName,
+		PreviewSecs:  config.Recorder.PreviewSecs,
+		MotionConfig: headerYAML,
+		Latitude:     float32(config.Recorder.Latitude),
+		Longitude:    float32(config.Recorder.Longitude),
+	}
+	fw.location = config.Recorder.Location()
+	fw.flushPeriod = time.Duration(config.FlushSecs) * time.Second
+	fw.motionConfig = string(motionYAML)
+	fw.minDiskSpace = config.MinDiskSpace
+	fw.storage = newStorage(config.OutputDir, config.Storage, config.MinDiskSpace)
+	if fw.storage != nil {
+		fw.storage.makeRoomLater()
 	}
 }
 
+// headerMotionConfig returns the motion config to put in the CPTV
+// header, leaving out the less important settings if they don't fit.
+func headerMotionConfig(motionYAML []byte) (string, error) {
+	if len(motionYAML) <= maxHeaderString {
+		return string(motionYAML), nil
+	}
+	var all yaml.MapSlice
+	if err := yaml.Unmarshal(motionYAML, &all); err != nil {
+		return "", err
+	}
+	var short yaml.MapSlice
+	for _, item := range all {
+		for _, key := range headerMotionKeys {
+			if item.Key == key {
+				short = append(short, item)
+			}
+		}
+	}
+	shortYAML, err := yaml.Marshal(short)
+	return string(shortYAML), err
+}
+
 type CPTVFileRecorder struct {
 	outputDir    string
 	header       cptv.Header
+	location     *recorder.Location
+	flushPeriod  time.Duration
+	motionConfig string
 	minDiskSpace uint64
+	storage      *storage
 
-	writer *cptv.FileWriter
+	// mu protects the recorder as the output directory can be changed
+	// from another goroutine.  It also covers the settings above when
+	// they are changed by Configure.
+	mu            sync.Mutex
+	writer        *cptvFileWriter
+	headerWritten bool
+	metadata      *recorder.RecordingMetadata
 }
 
 func (cfr *CPTVFileRecorder) CheckCanRecord() error {
+	cfr.mu.Lock()
+	defer cfr.mu.Unlock()
+
 	enoughSpace, err := checkDiskSpace(cfr.minDiskSpace, cfr.outputDir)
 	if err != nil {
 		return fmt.Errorf("Problem with checking disk space: %v", err)
 	} else if !enoughSpace {
+		if cfr.storage != nil {
+			cfr.storage.makeRoomLater()
+		}
 		return errors.New("Motion detected but not enough free disk space to start recording")
 	}
 	return nil
 }
 
 func (fw *CPTVFileRecorder) StartRecording() error {
+	return fw.StartRecordingPart(recorder.Part{})
+}
+
+// StartRecordingPart starts a recording which is labelled in its
+// metadata as a part of a longer recording.
+func (fw *CPTVFileRecorder) StartRecordingPart(part recorder.Part) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.startRecording(part)
+}
+
+func (fw *CPTVFileRecorder) startRecording(part recorder.Part) error {
 	filename := filepath.Join(fw.outputDir, newRecordingTempName())
-	log.Printf("recording started: %s", filename)
+	if part.Number > 0 {
+		log.Printf("recording started: %s (session %s part %d)", filename, part.SessionID, part.Number)
+	} else {
+		log.Printf("recording started: %s", filename)
+	}
 
-	writer, err := cptv.NewFileWriter(filename)
+	writer, err := newCPTVFileWriter(filename, fw.flushPeriod)
 	if err != nil {
 		return err
 	}
 
-	if err = writer.WriteHeader(fw.header); err != nil {
-		writer.Close()
-		return err
+	// The header is written with the first frame so that it has the
+	// time that frame was received.
+	fw.writer = writer
+	fw.headerWritten = false
+	fw.metadata = &recorder.RecordingMetadata{
+		Part:         part,
+		Location:     fw.location,
+		MotionConfig: fw.motionConfig,
 	}
+	return nil
+}
 
-	fw.writer = writer
+// writeHeader writes the CPTV header, if it hasn't been already, with
+// start as the time of the recording.
+func (fw *CPTVFileRecorder) writeHeader(start time.Time) error {
+	if fw.headerWritten {
+		return nil
+	}
+	header := fw.header
+	header.Timestamp = start
+	if err := fw.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	fw.headerWritten = true
 	return nil
 }
 
 func (fw *CPTVFileRecorder) StopRecording() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.stopRecording()
+}
+
+func (fw *CPTVFileRecorder) stopRecording() error {
 	if fw.writer != nil {
-		fw.writer.Close()
+		if err := fw.writeHeader(time.Now()); err != nil {
+			log.Printf("failed to write recording header: %v", err)
+		}
+		if err := fw.writer.Close(); err != nil {
+			log.Printf("failed to close recording: %v", err)
+		}
+
+		// The metadata is written first so it is there as soon as the
+		// recording appears.
+		metadataName := recordingMetadataName(recordingFinalName(fw.writer.Name()))
+		if err := fw.metadata.WriteFile(metadataName); err != nil {
+			log.Printf("failed to write recording metadata: %v", err)
+		}
 
 		finalName, err := renameTempRecording(fw.writer.Name())
 		log.Printf("recording stopped: %s\n", finalName)
 		fw.writer = nil
+		fw.metadata = nil
+
+		if fw.storage != nil {
+			fw.storage.makeRoomLater()
+		}
 
 		return err
 	}
 	return nil
 }
 
+// Stop finishes any recording in progress so that it isn't lost when the
+// camera connection goes away.
 func (fw *CPTVFileRecorder) Stop() {
-	if fw.writer != nil {
-		fw.writer.Close()
-		os.Remove(fw.writer.Name())
-		fw.writer = nil
+	if err := fw.StopRecording(); err != nil {
+		log.Printf("failed to finish recording: %v", err)
 	}
 }
 
+// SetOutputDir changes the directory recordings are written to.  A
+// recording in progress is finished and carries on in a new file in dir.
+func (fw *CPTVFileRecorder) SetOutputDir(dir string) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	recording := fw.writer != nil
+	var part recorder.Part
+	if recording {
+		part = fw.metadata.Part
+		if err := fw.stopRecording(); err != nil {
+			log.Printf("failed to finish recording: %v", err)
+		}
+	}
+
+	fw.outputDir = dir
+	if fw.storage != nil {
+		fw.storage.setDir(dir)
+	}
+
+	if !recording {
+		return nil
+	}
+	if part.SessionID != "" {
+		part = part.Next()
+	}
+	return fw.startRecording(part)
+}
+
 func (fw *CPTVFileRecorder) WriteFrame(frame *lepton3.Frame) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.writer == nil {
+		return errNotRecording
+	}
+	if err := fw.writeHeader(time.Now()); err != nil {
+		return err
+	}
 	return fw.writer.WriteFrame(frame)
 }
 
+func (fw *CPTVFileRecorder) WriteFrameWithMetadata(frame *lepton3.Frame, metadata recorder.FrameMetadata) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.writer == nil {
+		return errNotRecording
+	}
+	if err := fw.writeHeader(metadata.Time); err != nil {
+		return err
+	}
+	if err := fw.writer.WriteFrame(frame); err != nil {
+		return err
+	}
+	fw.metadata.Add(frame, metadata)
+	return nil
+}
+
 func newRecordingTempName() string {
 	return time.Now().Format("20060102.150405.000." + cptvTempExt)
 }
@@ -127,14 +323,101 @@ func recordingFinalName(filename string) string {
 	return reTempName.ReplaceAllString(filename, `$1`)
 }
 
-func deleteTempFiles(directory string) error {
-	matches, _ := filepath.Glob(filepath.Join(directory, "*."+cptvTempExt))
-	for _, filename := range matches {
-		if err := os.Remove(filename); err != nil {
+var reCPTVName = regexp.MustCompile(`(.+)\.cptv$`)
+
+// recordingMetadataName returns the name of the file holding the
+// metadata for a recording.
+func recordingMetadataName(filename string) string {
+	return reCPTVName.ReplaceAllString(filename, `$1`) + ".json"
+}
+
+// cptvFileWriter writes a CPTV file, regularly flushing what has been
+// written to disk so that the recording can be recovered if the recorder
+// stops unexpectedly.
+//
+// The frames are written as a series of gzip members, as the compressor
+// can't otherwise be flushed.  A new member is started after each flush.
+// Readers see the members as a single stream.  The frame encoding is the
+// same as cptv.Writer's, with one Compressor kept across the members as
+// each frame is a delta from the one before.
+type cptvFileWriter struct {
+	bw          *bufio.Writer
+	f           *os.File
+	comp        *cptv.Compressor
+	bldr        *cptv.Builder
+	flushPeriod time.Duration
+	lastFlush   time.Time
+}
+
+func newCPTVFileWriter(filename string, flushPeriod time.Duration) (*cptvFileWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &cptvFileWriter{
+		bw:          bufio.NewWriter(f),
+		f:           f,
+		comp:        cptv.NewCompressor(),
+		flushPeriod: flushPeriod,
+		lastFlush:   time.Now(),
+	}, nil
+}
+
+func (w *cptvFileWriter) Name() string {
+	return w.f.Name()
+}
+
+// WriteHeader writes the CPTV header in a gzip member of its own.
+func (w *cptvFileWriter) WriteHeader(header cptv.Header) error {
+	hw := cptv.NewWriter(w.bw)
+	if err := hw.WriteHeader(header); err != nil {
+		return err
+	}
+	return hw.Close()
+}
+
+func (w *cptvFileWriter) WriteFrame(frame *lepton3.Frame) error {
+	if w.bldr == nil {
+		w.bldr = cptv.NewBuilder(w.bw)
+	}
+	bitWidth, compFrame := w.comp.Next(frame)
+	fields := cptv.NewFieldWriter()
+	fields.Uint32(cptv.TimeOn, uint32(frame.Status.TimeOn/time.Millisecond))
+	fields.Uint32(cptv.LastFFCTime, uint32(frame.Status.LastFFCTime/time.Millisecond))
+	fields.Uint8(cptv.BitWidth, bitWidth)
+	fields.Uint32(cptv.FrameSize, uint32(len(compFrame)))
+	if err := w.bldr.WriteFrame(fields, compFrame); err != nil {
+		return err
+	}
+
+	if w.flushPeriod > 0 && time.Since(w.lastFlush) >= w.flushPeriod {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes all of the frames written so far to disk.
+func (w *cptvFileWriter) Flush() error {
+	w.lastFlush = time.Now()
+	if w.bldr != nil {
+		err := w.bldr.Close()
+		w.bldr = nil
+		if err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func (w *cptvFileWriter) Close() error {
+	err := w.Flush()
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 func checkDiskSpace(mb uint64, dir string) (bool, error) {
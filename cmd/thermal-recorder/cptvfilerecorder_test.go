@@ -0,0 +1,192 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cptv "github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/TheCacophonyProject/thermal-recorder/recorder"
+)
+
+func TestRecordingMetadataName(t *testing.T) {
+	assert.Equal(t, "/a/20190101.120000.000.json", recordingMetadataName("/a/20190101.120000.000.cptv"))
+}
+
+func TestRecordingWritesMetadataSidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := GetDefaultConfigFromFile()
+	config.OutputDir = dir
+	fw := NewCPTVFileRecorder(config)
+
+	require.NoError(t, fw.StartRecording())
+	for i := 0; i < 3; i++ {
+		frame := new(lepton3.Frame)
+		frame.Status.FrameCount = i
+		require.NoError(t, fw.WriteFrameWithMetadata(frame, recorder.FrameMetadata{
+			Score:   float64(i),
+			Trigger: recorder.TriggerMotion,
+		}))
+	}
+	require.NoError(t, fw.StopRecording())
+
+	recordings, _ := filepath.Glob(filepath.Join(dir, "*.cptv"))
+	require.Len(t, recordings, 1)
+	buf, err := ioutil.ReadFile(recordingMetadataName(recordings[0]))
+	require.NoError(t, err)
+
+	var metadata recorder.RecordingMetadata
+	require.NoError(t, json.Unmarshal(buf, &metadata))
+	assert.Equal(t, 2.0, metadata.MaxScore)
+	require.Len(t, metadata.Frames, 3)
+	assert.Equal(t, 2, metadata.Frames[2].Telemetry.FrameCount)
+	assert.Equal(t, recorder.TriggerMotion, metadata.Frames[2].Trigger)
+	assert.Contains(t, metadata.MotionConfig, "ffc-period: 10s")
+
+	temps, _ := filepath.Glob(filepath.Join(dir, "*.temp"))
+	assert.Empty(t, temps)
+}
+
+func TestRecordingPartWrittenToMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := GetDefaultConfigFromFile()
+	config.OutputDir = dir
+	fw := NewCPTVFileRecorder(config)
+
+	part := recorder.Part{SessionID: "0123456789abcdef", Number: 2}
+	require.NoError(t, fw.StartRecordingPart(part))
+	require.NoError(t, fw.WriteFrameWithMetadata(new(lepton3.Frame), recorder.FrameMetadata{}))
+	require.NoError(t, fw.StopRecording())
+
+	recordings, _ := filepath.Glob(filepath.Join(dir, "*.cptv"))
+	require.Len(t, recordings, 1)
+	buf, err := ioutil.ReadFile(recordingMetadataName(recordings[0]))
+	require.NoError(t, err)
+
+	var metadata recorder.RecordingMetadata
+	require.NoError(t, json.Unmarshal(buf, &metadata))
+	assert.Equal(t, part, metadata.Part)
+}
+
+func TestHeaderHasFirstFrameTimeAndLocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := GetDefaultConfigFromFile()
+	config.OutputDir = dir
+	config.Recorder.Latitude = -43.5
+	config.Recorder.Longitude = 172.5
+	config.Recorder.Altitude = 20
+	fw := NewCPTVFileRecorder(config)
+
+	start := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	require.NoError(t, fw.StartRecording())
+	for i := 0; i < 2; i++ {
+		require.NoError(t, fw.WriteFrameWithMetadata(new(lepton3.Frame), recorder.FrameMetadata{
+			Time: start.Add(time.Duration(i) * time.Second),
+		}))
+	}
+	require.NoError(t, fw.StopRecording())
+
+	recordings, _ := filepath.Glob(filepath.Join(dir, "*.cptv"))
+	require.Len(t, recordings, 1)
+	r, err := cptv.NewFileReader(recordings[0])
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, start, r.Timestamp().UTC())
+	assert.Equal(t, float32(-43.5), r.Latitude())
+	assert.Equal(t, float32(172.5), r.Longitude())
+
+	buf, err := ioutil.ReadFile(recordingMetadataName(recordings[0]))
+	require.NoError(t, err)
+	var metadata recorder.RecordingMetadata
+	require.NoError(t, json.Unmarshal(buf, &metadata))
+	assert.Equal(t, &recorder.Location{Latitude: -43.5, Longitude: 172.5, Altitude: 20}, metadata.Location)
+}
+
+func TestRecordingWithoutFramesHasHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := GetDefaultConfigFromFile()
+	config.OutputDir = dir
+	fw := NewCPTVFileRecorder(config)
+
+	require.NoError(t, fw.StartRecording())
+	require.NoError(t, fw.StopRecording())
+
+	recordings, _ := filepath.Glob(filepath.Join(dir, "*.cptv"))
+	require.Len(t, recordings, 1)
+	r, err := cptv.NewFileReader(recordings[0])
+	require.NoError(t, err)
+	defer r.Close()
+	assert.WithinDuration(t, time.Now(), r.Timestamp(), time.Minute)
+}
+
+func TestStopFinishesRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := GetDefaultConfigFromFile()
+	config.OutputDir = dir
+	fw := NewCPTVFileRecorder(config)
+
+	require.NoError(t, fw.StartRecording())
+	require.NoError(t, fw.WriteFrame(new(lepton3.Frame)))
+	fw.Stop()
+
+	recordings, _ := filepath.Glob(filepath.Join(dir, "*.cptv"))
+	assert.Len(t, recordings, 1)
+	temps, _ := filepath.Glob(filepath.Join(dir, "*.temp"))
+	assert.Empty(t, temps)
+}
+
+func TestHeaderMotionConfigShortened(t *testing.T) {
+	config := GetDefaultConfigFromFile()
+	motionYAML, err := yaml.Marshal(config.Motion)
+	require.NoError(t, err)
+
+	headerYAML, err := headerMotionConfig(motionYAML)
+	require.NoError(t, err)
+	assert.True(t, len(headerYAML) <= maxHeaderString)
+	assert.Contains(t, headerYAML, "detector: frame-diff")
+	assert.Contains(t, headerYAML, "delta-thresh: 50")
+
+	short := []byte("delta-thresh: 50\n")
+	headerYAML, err = headerMotionConfig(short)
+	require.NoError(t, err)
+	assert.Equal(t, string(short), headerYAML)
+}
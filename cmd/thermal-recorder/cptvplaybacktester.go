@@ -34,10 +34,17 @@ import (
 
 type EventLoggingRecordingListener struct {
 	config               *Config
+	processor            *motion.MotionProcessor
 	gaps                 int
 	frameCount           int
 	motionDetectedCount  int
 	lastDetection        int
+	recentDetections     []int
+	trackCount           int
+	rejections           motion.Rejections
+	sceneChanges         int
+	minDeltaThresh       uint16
+	maxDeltaThresh       uint16
 	verbose              bool
 	recordedFrames       string
 	motionDetectedFrames string
@@ -55,6 +62,31 @@ func (p *EventLoggingRecordingListener) MotionDetected() {
 	}
 	p.motionDetectedCount++
 	p.lastDetection = p.frameCount
+	p.recentDetections = append(p.recentDetections, p.frameCount)
+	if len(p.recentDetections) > p.triggerWindow() {
+		p.recentDetections = p.recentDetections[1:]
+	}
+}
+
+// triggerWindow returns how many frames back motion is counted when
+// deciding whether to start recording.
+func (p *EventLoggingRecordingListener) triggerWindow() int {
+	if p.config.Motion.TriggerWindow > 0 {
+		return p.config.Motion.TriggerWindow
+	}
+	return p.config.Motion.TriggerFrames
+}
+
+// motionStart returns the first frame with motion which counted towards
+// starting the current recording.
+func (p *EventLoggingRecordingListener) motionStart() int {
+	windowStart := p.frameCount - p.triggerWindow() + 1
+	for _, frame := range p.recentDetections {
+		if frame >= windowStart {
+			return frame
+		}
+	}
+	return p.frameCount
 }
 
 func (p *EventLoggingRecordingListener) RecordingStarted() {
@@ -62,15 +94,52 @@ func (p *EventLoggingRecordingListener) RecordingStarted() {
 		log.Printf("%d: Recording Started", p.frameCount)
 	}
 	p.recordedFrames += fmt.Sprintf("(%d:", p.frameCount)
-	p.motionDetectedFrames += fmt.Sprintf("(%d:", p.frameCount-p.config.Motion.TriggerFrames+1)
+	p.motionDetectedFrames += fmt.Sprintf("(%d:", p.motionStart())
 }
 
 func (p *EventLoggingRecordingListener) RecordingEnded() {
 	if p.verbose {
 		log.Printf("%d: Recording Ended", p.frameCount)
 	}
-	p.recordedFrames += fmt.Sprintf("%d)", p.frameCount)
-	p.motionDetectedFrames += fmt.Sprintf("%d)", p.frameCount-p.config.Recorder.MinSecs*lepton3.FramesHz)
+	// Frames held while waiting for motion to resume aren't recorded.
+	end := p.frameCount - p.processor.HeldFrames()
+	p.recordedFrames += fmt.Sprintf("%d)", end)
+	p.motionDetectedFrames += fmt.Sprintf("%d)", end-p.config.Recorder.MinSecs*lepton3.FramesHz)
+}
+
+func (p *EventLoggingRecordingListener) TrackStarted(track *motion.Track) {
+	if p.verbose {
+		log.Printf("%d: Track %d started at (%.1f, %.1f)", p.frameCount, track.ID, track.Last().X, track.Last().Y)
+	}
+	p.trackCount++
+}
+
+func (p *EventLoggingRecordingListener) TrackEnded(track *motion.Track) {
+	if p.verbose {
+		log.Printf("%d: Track %d ended, frames %d to %d", p.frameCount, track.ID, track.StartFrame, track.EndFrame)
+	}
+}
+
+func (p *EventLoggingRecordingListener) SceneChangeSuppressed(changedPixels int) {
+	if p.verbose {
+		log.Printf("%d: Scene change suppressed, %d pixels changed", p.frameCount, changedPixels)
+	}
+	p.sceneChanges++
+}
+
+func (p *EventLoggingRecordingListener) deltaThreshUsed(thresh uint16) {
+	if thresh == 0 {
+		return
+	}
+	if p.minDeltaThresh == 0 || thresh < p.minDeltaThresh {
+		p.minDeltaThresh = thresh
+	}
+	if thresh > p.maxDeltaThresh {
+		if p.verbose && p.maxDeltaThresh != 0 {
+			log.Printf("%d: delta-thresh increased to %d", p.frameCount, thresh)
+		}
+		p.maxDeltaThresh = thresh
+	}
 }
 
 func (p *EventLoggingRecordingListener) completed() {
@@ -145,7 +214,7 @@ func (cpt *CPTVPlaybackTester) LoadAllCptvFrames(filename string) []*lepton3.Fra
 func (cpt *CPTVPlaybackTester) Detect(filename string) *EventLoggingRecordingListener {
 	verbose := cpt.config.Motion.Verbose
 	if verbose {
-		log.Printf("TestFile is %s", filename)
+		log.Printf("TestFile is %s, detector is %s", filename, cpt.config.Motion.Detector)
 	}
 
 	listener := new(EventLoggingRecordingListener)
@@ -154,7 +223,12 @@ func (cpt *CPTVPlaybackTester) Detect(filename string) *EventLoggingRecordingLis
 
 	recorder := new(recorder.NoWriteRecorder)
 
-	processor := motion.NewMotionProcessor(&cpt.config.Motion, &cpt.config.Recorder, listener, recorder)
+	processor, err := motion.NewMotionProcessor(&cpt.config.Motion, &cpt.config.Recorder, listener, recorder)
+	if err != nil {
+		log.Printf("Could not create motion processor %v", err)
+		return listener
+	}
+	listener.processor = processor
 
 	file, reader, err := motionTesterLoadFile(filename)
 	if err != nil {
@@ -173,6 +247,7 @@ func (cpt *CPTVPlaybackTester) Detect(filename string) *EventLoggingRecordingLis
 				log.Printf("Last Frame gap %d", listener.frameCount-listener.lastDetection)
 				log.Printf("Motion detected frames %d out of frames %d (%d)", listener.motionDetectedCount, listener.frameCount, listener.gaps)
 			}
+			listener.rejections = processor.Rejections()
 			return listener
 		}
 
@@ -182,6 +257,7 @@ func (cpt *CPTVPlaybackTester) Detect(filename string) *EventLoggingRecordingLis
 		frame.Status.TimeOn = now
 
 		processor.ProcessFrame(frame)
+		listener.deltaThreshUsed(processor.LastResult().DeltaThresh)
 		listener.frameCount++
 	}
 }
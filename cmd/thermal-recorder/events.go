@@ -0,0 +1,41 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"log"
+
+	"github.com/TheCacophonyProject/thermal-recorder/events"
+	"github.com/TheCacophonyProject/thermal-recorder/motion"
+)
+
+// eventListener records the motion processor's events which are
+// interesting when looking back at what a camera did.
+type eventListener struct{}
+
+func (l *eventListener) MotionDetected()                  {}
+func (l *eventListener) RecordingStarted()                {}
+func (l *eventListener) RecordingEnded()                  {}
+func (l *eventListener) TrackStarted(track *motion.Track) {}
+func (l *eventListener) TrackEnded(track *motion.Track)   {}
+
+func (l *eventListener) SceneChangeSuppressed(changedPixels int) {
+	log.Printf("motion suppressed due to scene change (%d pixels changed)", changedPixels)
+	go events.Queue("scene-change", map[string]interface{}{
+		"changed-pixels": changedPixels,
+	})
+}
@@ -20,6 +20,9 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/TheCacophonyProject/lepton3"
 	arg "github.com/alexflint/go-arg"
@@ -39,16 +42,34 @@ const (
 )
 
 var (
-	version   = "<not set>"
-	processor *motion.MotionProcessor
+	version = "<not set>"
+
+	// The D-Bus service uses the motion processor from other goroutines
+	// and it is replaced when the config is reloaded, so it is accessed
+	// through getProcessor and setProcessor.
+	processorMu     sync.Mutex
+	activeProcessor *motion.MotionProcessor
 )
 
+func getProcessor() *motion.MotionProcessor {
+	processorMu.Lock()
+	defer processorMu.Unlock()
+	return activeProcessor
+}
+
+func setProcessor(p *motion.MotionProcessor) {
+	processorMu.Lock()
+	defer processorMu.Unlock()
+	activeProcessor = p
+}
+
 type Args struct {
 	ConfigFile         string `arg:"-c,--config" help:"path to configuration file"`
 	UploaderConfigFile string `arg:"-u,--uploader-config" help:"path to uploader config file"`
 	Timestamps         bool   `arg:"-t,--timestamps" help:"include timestamps in log output"`
 	TestCptvFile       string `arg:"-f, --testfile" help:"Run a CPTV file through to see what the results are"`
 	Verbose            bool   `arg:"-v, --verbose" help:"Make logging more verbose"`
+	Detector           string `arg:"-d, --detector" help:"Motion detector to use when running a CPTV file (overrides config)"`
 }
 
 func (Args) Version() string {
@@ -87,15 +108,27 @@ func runMain() error {
 
 	if args.TestCptvFile != "" {
 		conf.Motion.Verbose = args.Verbose
+		if args.Detector != "" {
+			conf.Motion.Detector = args.Detector
+			if err := conf.Motion.Validate(); err != nil {
+				return err
+			}
+		}
 		results := NewCPTVPlaybackTester(conf).Detect(args.TestCptvFile)
-		log.Printf("Detected: %-16s Recorded: %-16s Motion frames: %d/%d", results.motionDetectedFrames, results.recordedFrames, results.motionDetectedCount, results.frameCount)
+		log.Printf("Detected: %-16s Recorded: %-16s Motion frames: %d/%d Tracks: %d Delta thresh: %d-%d Rejected: %+v Scene changes: %d",
+			results.motionDetectedFrames, results.recordedFrames, results.motionDetectedCount, results.frameCount,
+			results.trackCount, results.minDeltaThresh, results.maxDeltaThresh, results.rejections, results.sceneChanges)
 		return nil
 	}
 
 	logConfig(conf)
 
+	outputs := newOutputDirs(conf, args.ConfigFile)
+	reloader := newConfigReloader(args.ConfigFile, args.UploaderConfigFile, outputs)
+	go reloadOnHangup(reloader)
+
 	log.Println("starting d-bus service")
-	err = startService(conf.OutputDir)
+	err = startService(outputs, reloader)
 	if err != nil {
 		return err
 	}
@@ -108,12 +141,15 @@ func runMain() error {
 	turret := NewTurretController(conf.Turret)
 	go turret.Start()
 
-	log.Println("deleting temp files")
-	if err := deleteTempFiles(conf.OutputDir); err != nil {
+	log.Println("recovering temp files")
+	if err := recoverTempFiles(conf.OutputDir); err != nil {
 		return err
 	}
 
 	for {
+		// Settings reloaded while there was no camera connection.
+		reloader.apply(false)
+
 		// Set up listener for frames sent by leptond.
 		os.Remove(conf.FrameInput)
 		listener, err := net.Listen("unixpacket", conf.FrameInput)
@@ -131,28 +167,32 @@ func runMain() error {
 		// Prevent concurrent connections.
 		listener.Close()
 
-		err = handleConn(conn, conf, turret)
+		err = handleConn(conn, conf, outputs, reloader, turret)
 		log.Printf("camera connection ended with: %v", err)
 	}
 }
 
-func handleConn(conn net.Conn, conf *Config, turret *TurretController) error {
-
-	totalFrames := 0
+// reloadOnHangup reloads the config files each time the recorder is sent
+// SIGHUP.
+func reloadOnHangup(reloader *configReloader) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if _, err := reloader.reload(); err != nil {
+			log.Printf("config not reloaded: %v", err)
+		}
+	}
+}
 
-	cptvRecorder := NewCPTVFileRecorder(conf)
-	defer cptvRecorder.Stop()
-	var recorder recorder.Recorder = cptvRecorder
+func handleConn(conn net.Conn, conf *Config, outputs *outputDirs, reloader *configReloader, turret *TurretController) error {
 
-	var throttledRecorder *throttle.ThrottledRecorder
+	totalFrames := 0
 
-	if conf.Throttler.ApplyThrottling {
-		minRecordingLength := conf.Recorder.MinSecs + conf.Recorder.PreviewSecs
-		throttledRecorder = throttle.NewThrottledRecorder(cptvRecorder, new(throttle.ThrottledEventRecorder), &conf.Throttler, minRecordingLength)
-		recorder = throttledRecorder
+	processor, throttledRecorder, err := startProcessor(conf, outputs)
+	if err != nil {
+		return err
 	}
-
-	processor = motion.NewMotionProcessor(&conf.Motion, &conf.Recorder, nil, recorder)
+	defer outputs.closeRecorder()
 
 	rawFrame := new(lepton3.RawFrame)
 
@@ -167,30 +207,68 @@ func handleConn(conn net.Conn, conf *Config, turret *TurretController) error {
 
 		if totalFrames%frameLogIntervalFirstMin == 0 &&
 			totalFrames <= 60*framesHz || totalFrames%frameLogInterval == 0 {
-			log.Printf("%d frames for this connection", totalFrames)
+			log.Printf("%d frames for this connection, delta-thresh %d, rejected objects %+v",
+				totalFrames, processor.LastResult().DeltaThresh, processor.Rejections())
 		}
 
 		if throttledRecorder != nil {
 			throttledRecorder.NextFrame()
 		}
 		processor.Process(rawFrame)
+
+		switch reloader.apply(processor.IsRecording()) {
+		case reloadRecorder:
+			outputs.reconfigureRecorder()
+		case reloadProcessor:
+			newProcessor, newThrottledRecorder, err := startProcessor(conf, outputs)
+			if err != nil {
+				log.Printf("keeping the old motion processor, failed to make a new one: %v", err)
+				break
+			}
+			processor, throttledRecorder = newProcessor, newThrottledRecorder
+		}
 	}
 }
 
+// startProcessor sets up the recorders and motion processor for conf,
+// replacing any which are running.  The throttled recorder is returned
+// too if throttling is on.
+func startProcessor(conf *Config, outputs *outputDirs) (*motion.MotionProcessor, *throttle.ThrottledRecorder, error) {
+	cptvRecorder := outputs.newRecorder()
+	// Further sinks (eg previews or streams) can be added to the fan out.
+	sinks := recorder.NewFanOutRecorder(cptvRecorder)
+	var rec recorder.Recorder = sinks
+
+	var throttledRecorder *throttle.ThrottledRecorder
+
+	if conf.Throttler.ApplyThrottling {
+		minRecordingLength := conf.Recorder.MinSecs + conf.Recorder.PreviewSecs
+		throttledRecorder = throttle.NewThrottledRecorder(rec, new(throttle.ThrottledEventRecorder), &conf.Throttler, minRecordingLength)
+		rec = throttledRecorder
+	}
+
+	processor, err := motion.NewMotionProcessor(&conf.Motion, &conf.Recorder, new(eventListener), rec)
+	if err != nil {
+		return nil, nil, err
+	}
+	outputs.useRecorder(cptvRecorder)
+	setProcessor(processor)
+	return processor, throttledRecorder, nil
+}
+
 func logConfig(conf *Config) {
 	log.Printf("device name: %s", conf.DeviceName)
 	log.Printf("frame input: %s", conf.FrameInput)
 	log.Printf("output dir: %s", conf.OutputDir)
 	log.Printf("recording limits: %ds to %ds", conf.Recorder.MinSecs, conf.Recorder.MaxSecs)
 	log.Printf("preview seconds: %d", conf.Recorder.PreviewSecs)
+	log.Printf("merge gap seconds: %d", conf.Recorder.MergeGapSecs)
 	log.Printf("minimum disk space: %d", conf.MinDiskSpace)
+	log.Printf("flush seconds: %d", conf.FlushSecs)
+	log.Printf("storage: %+v", conf.Storage)
 	log.Printf("motion: %+v", conf.Motion)
 	log.Printf("throttler: %+v", conf.Throttler)
-	if !conf.Recorder.WindowStart.IsZero() {
-		log.Printf("recording window: %02d:%02d to %02d:%02d",
-			conf.Recorder.WindowStart.Hour(), conf.Recorder.WindowStart.Minute(),
-			conf.Recorder.WindowEnd.Hour(), conf.Recorder.WindowEnd.Minute())
-	}
+	log.Printf("recording schedule: %s", recorder.NewSchedule(&conf.Recorder))
 	if conf.Turret.Active {
 		log.Printf("Turret active")
 		log.Printf("\tPID: %v", conf.Turret.PID)
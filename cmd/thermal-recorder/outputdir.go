@@ -0,0 +1,276 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/TheCacophonyProject/thermal-recorder/events"
+	"github.com/TheCacophonyProject/thermal-recorder/motion"
+)
+
+// outputDirs lets the directory recordings are written to be changed
+// while the recorder is running, eg. to use a USB drive when one is
+// plugged in.  The change is saved to the config file so it is kept
+// when the recorder restarts.
+type outputDirs struct {
+	mu         sync.Mutex
+	conf       *Config
+	configFile string
+	recorder   *CPTVFileRecorder
+	onMoved    func(srcDir, dstDir string, err error)
+}
+
+func newOutputDirs(conf *Config, configFile string) *outputDirs {
+	return &outputDirs{
+		conf:       conf,
+		configFile: configFile,
+		onMoved: func(srcDir, dstDir string, err error) {
+			details := map[string]interface{}{
+				"from": srcDir,
+				"to":   dstDir,
+			}
+			if err != nil {
+				details["error"] = err.Error()
+			}
+			go events.Queue("recordings-moved", details)
+		},
+	}
+}
+
+// newRecorder creates a recorder for the current output directory.  It
+// isn't switched over if the directory is changed until useRecorder is
+// called.
+func (o *outputDirs) newRecorder() *CPTVFileRecorder {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return NewCPTVFileRecorder(o.conf)
+}
+
+// useRecorder finishes with the recorder in use, if there is one, and
+// switches to r.  r picks up any changes made since it was created.
+func (o *outputDirs) useRecorder(r *CPTVFileRecorder) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.recorder != nil {
+		o.recorder.Stop()
+	}
+	r.Configure(o.conf)
+	o.recorder = r
+}
+
+// reconfigureRecorder makes the recorder use the current config for the
+// recordings it starts from now on.
+func (o *outputDirs) reconfigureRecorder() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.recorder != nil {
+		o.recorder.Configure(o.conf)
+	}
+}
+
+// closeRecorder finishes any recording in progress and stops the
+// recorder from being switched over.
+func (o *outputDirs) closeRecorder() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.recorder != nil {
+		o.recorder.Stop()
+		o.recorder = nil
+	}
+}
+
+// set changes the output directory to dir, optionally moving the
+// finished recordings from the old directory to the new one.  Moving can
+// take a while so it is done in the background once the new directory
+// is in use.
+func (o *outputDirs) set(dir string, moveRecordings bool) error {
+	if err := checkWritableDir(dir); err != nil {
+		return err
+	}
+
+	oldDir, err := o.switchDir(dir)
+	if err != nil {
+		return err
+	}
+	if moveRecordings && dir != oldDir {
+		go func() {
+			err := moveRecordingFiles(oldDir, dir)
+			if err != nil {
+				log.Printf("failed to move recordings from %s to %s: %v", oldDir, dir, err)
+			} else {
+				log.Printf("finished moving recordings from %s to %s", oldDir, dir)
+			}
+			o.onMoved(oldDir, dir, err)
+		}()
+	}
+	return nil
+}
+
+// switchDir makes dir the output directory, returning the previous one.
+func (o *outputDirs) switchDir(dir string) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	oldDir := o.conf.OutputDir
+	if dir == oldDir {
+		return oldDir, nil
+	}
+	if o.recorder != nil {
+		if err := o.recorder.SetOutputDir(dir); err != nil {
+			log.Printf("failed to continue recording in %s: %v", dir, err)
+		}
+	}
+	o.conf.OutputDir = dir
+	log.Printf("output dir changed from %s to %s", oldDir, dir)
+	if err := setConfigOutputDir(o.configFile, dir); err != nil {
+		return oldDir, fmt.Errorf("failed to save output dir: %v", err)
+	}
+	return oldDir, nil
+}
+
+// dir returns the current output directory.
+func (o *outputDirs) dir() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.conf.OutputDir
+}
+
+// masks returns the areas motion detection is currently ignoring, which
+// change when the config is reloaded.
+func (o *outputDirs) masks() []motion.MaskConfig {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.conf.Motion.Masks
+}
+
+// reset changes the output directory back to the default.  Recordings
+// aren't moved as the old directory may be about to go away.
+func (o *outputDirs) reset() error {
+	return o.set(defaultConfig.OutputDir, false)
+}
+
+func checkWritableDir(dir string) error {
+	f, err := ioutil.TempFile(dir, ".write-test")
+	if err != nil {
+		return fmt.Errorf("can't write to %s: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+var reOutputDirSetting = regexp.MustCompile(`(?m)^output-dir:.*$`)
+
+// setConfigOutputDir updates the output-dir setting in a config file,
+// leaving the rest of the file (including comments) as it is.
+func setConfigOutputDir(filename, dir string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	setting := []byte(fmt.Sprintf("output-dir: %q", dir))
+	if reOutputDirSetting.Match(buf) {
+		buf = reOutputDirSetting.ReplaceAllLiteral(buf, setting)
+	} else {
+		if len(buf) > 0 && buf[len(buf)-1] != '\n' {
+			buf = append(buf, '\n')
+		}
+		buf = append(append(buf, setting...), '\n')
+	}
+
+	tempName := filename + ".tmp"
+	if err := ioutil.WriteFile(tempName, buf, info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tempName, filename)
+}
+
+// moveRecordingFiles moves the finished recordings, along with their
+// metadata files, from srcDir to dstDir.
+func moveRecordingFiles(srcDir, dstDir string) error {
+	matches, err := filepath.Glob(filepath.Join(srcDir, "*.cptv"))
+	if err != nil {
+		return err
+	}
+	log.Printf("moving %d recordings from %s to %s", len(matches), srcDir, dstDir)
+	for _, filename := range matches {
+		// The metadata goes first so it is there when the recording
+		// appears.
+		metadataName := recordingMetadataName(filename)
+		if _, err := os.Stat(metadataName); err == nil {
+			if err := moveFile(metadataName, dstDir); err != nil {
+				return err
+			}
+		}
+		if err := moveFile(filename, dstDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFile moves a file into dstDir, copying it if it is on a different
+// file system.  The file doesn't appear in dstDir until it is complete.
+func moveFile(filename, dstDir string) error {
+	dstName := filepath.Join(dstDir, filepath.Base(filename))
+	if err := os.Rename(filename, dstName); err == nil {
+		return nil
+	}
+
+	tempName := dstName + ".moving"
+	if err := copyFile(filename, tempName); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	if err := os.Rename(tempName, dstName); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+func copyFile(srcName, dstName string) error {
+	src, err := os.Open(srcName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,160 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheCacophonyProject/thermal-recorder/recorder"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "output")
+	require.NoError(t, err)
+	return dir
+}
+
+func writeConfigFile(t *testing.T, dir, content string) string {
+	filename := filepath.Join(dir, "thermal-recorder.yaml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	return filename
+}
+
+func readFile(t *testing.T, filename string) string {
+	buf, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	return string(buf)
+}
+
+func TestSetConfigOutputDirReplacesSetting(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	filename := writeConfigFile(t, dir, "# comment\noutput-dir: /var/spool/cptv\nframe-input: /var/run/lepton-frames\n")
+
+	require.NoError(t, setConfigOutputDir(filename, "/media/usb"))
+
+	assert.Equal(t,
+		"# comment\noutput-dir: \"/media/usb\"\nframe-input: /var/run/lepton-frames\n",
+		readFile(t, filename))
+}
+
+func TestSetConfigOutputDirAddsSetting(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	filename := writeConfigFile(t, dir, "frame-input: /var/run/lepton-frames")
+
+	require.NoError(t, setConfigOutputDir(filename, "/media/usb"))
+
+	assert.Equal(t,
+		"frame-input: /var/run/lepton-frames\noutput-dir: \"/media/usb\"\n",
+		readFile(t, filename))
+}
+
+func TestMoveRecordingFiles(t *testing.T) {
+	src := tempDir(t)
+	defer os.RemoveAll(src)
+	dst := tempDir(t)
+	defer os.RemoveAll(dst)
+
+	files := map[string]string{
+		"20190101.120000.000.cptv": "recording",
+		"20190101.120000.000.json": "metadata",
+		"20190101.130000.000.cptv": "recording without metadata",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(src, name), []byte(content), 0644))
+	}
+	// Recordings in progress stay where they are.
+	tempName := filepath.Join(src, "20190101.140000.000."+cptvTempExt)
+	require.NoError(t, ioutil.WriteFile(tempName, nil, 0644))
+
+	require.NoError(t, moveRecordingFiles(src, dst))
+
+	for name, content := range files {
+		assert.Equal(t, content, readFile(t, filepath.Join(dst, name)))
+	}
+	left, _ := filepath.Glob(filepath.Join(src, "*"))
+	assert.Equal(t, []string{tempName}, left)
+}
+
+func TestSetOutputDirWhileRecording(t *testing.T) {
+	configDir := tempDir(t)
+	defer os.RemoveAll(configDir)
+	oldDir := tempDir(t)
+	defer os.RemoveAll(oldDir)
+	newDir := tempDir(t)
+	defer os.RemoveAll(newDir)
+
+	configFile := writeConfigFile(t, configDir, "output-dir: "+oldDir+"\n")
+	config := GetDefaultConfigFromFile()
+	config.OutputDir = oldDir
+	outputs := newOutputDirs(config, configFile)
+	moved := make(chan error, 1)
+	outputs.onMoved = func(srcDir, dstDir string, err error) {
+		moved <- err
+	}
+	fw := outputs.newRecorder()
+	outputs.useRecorder(fw)
+	defer outputs.closeRecorder()
+
+	part, err := recorder.NewPart()
+	require.NoError(t, err)
+	require.NoError(t, fw.StartRecordingPart(part))
+	writeFrames := func(count int) {
+		for i := 0; i < count; i++ {
+			require.NoError(t, fw.WriteFrameWithMetadata(new(lepton3.Frame), recorder.FrameMetadata{}))
+		}
+	}
+	writeFrames(3)
+	require.NoError(t, outputs.set(newDir, true))
+	writeFrames(2)
+	require.NoError(t, fw.StopRecording())
+	require.NoError(t, <-moved)
+
+	left, _ := filepath.Glob(filepath.Join(oldDir, "*"))
+	assert.Empty(t, left)
+	recordings, _ := filepath.Glob(filepath.Join(newDir, "*.cptv"))
+	require.Len(t, recordings, 2)
+	assert.Equal(t, 3, countFrames(t, recordings[0]))
+	assert.Equal(t, 2, countFrames(t, recordings[1]))
+
+	var metadata recorder.RecordingMetadata
+	require.NoError(t, json.Unmarshal([]byte(readFile(t, recordingMetadataName(recordings[1]))), &metadata))
+	assert.Equal(t, part.Next(), metadata.Part)
+
+	assert.Equal(t, newDir, config.OutputDir)
+	assert.Contains(t, readFile(t, configFile), "output-dir: \""+newDir+"\"")
+}
+
+func TestSetOutputDirMustBeWritable(t *testing.T) {
+	configDir := tempDir(t)
+	defer os.RemoveAll(configDir)
+	configFile := writeConfigFile(t, configDir, "")
+	config := GetDefaultConfigFromFile()
+	outputs := newOutputDirs(config, configFile)
+
+	assert.Error(t, outputs.set(filepath.Join(configDir, "missing"), false))
+	assert.Equal(t, defaultConfig.OutputDir, config.OutputDir)
+}
@@ -0,0 +1,132 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	cptv "github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/lepton3"
+
+	"github.com/TheCacophonyProject/thermal-recorder/recorder"
+)
+
+const recoveringExt = ".recovering"
+
+// recoverTempFiles turns recordings which were still being written when
+// the recorder last stopped unexpectedly (eg. because of a power cut)
+// into normal recordings.  Each is cut off after its last complete frame
+// and is flagged as recovered in its metadata file.  Temp files which
+// can't be recovered are deleted, as are partly written metadata files.
+func recoverTempFiles(directory string) error {
+	// Left over from a recovery which was itself interrupted, or from
+	// writing a metadata file.  The metadata is written again when its
+	// recording is recovered.
+	var stale []string
+	for _, pattern := range []string{"*" + recoveringExt, "*.json.temp"} {
+		matches, _ := filepath.Glob(filepath.Join(directory, pattern))
+		stale = append(stale, matches...)
+	}
+	for _, filename := range stale {
+		if err := os.Remove(filename); err != nil {
+			return err
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(directory, "*."+cptvTempExt))
+	for _, tempName := range matches {
+		frames, err := recoverRecording(tempName)
+		if err != nil {
+			log.Printf("deleting %s which can't be recovered: %v", tempName, err)
+			if err := os.Remove(tempName); err != nil {
+				return err
+			}
+			continue
+		}
+		log.Printf("recovered %d frames from %s", frames, tempName)
+	}
+	return nil
+}
+
+// recoverRecording copies the complete frames in a temp file into a new
+// recording, returning how many frames were recovered.
+func recoverRecording(tempName string) (int, error) {
+	r, err := cptv.NewFileReader(tempName)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	finalName := recordingFinalName(tempName)
+	recoveringName := finalName + recoveringExt
+	w, err := newCPTVFileWriter(recoveringName, 0)
+	if err != nil {
+		return 0, err
+	}
+	frames, err := copyCompleteFrames(r, w)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && frames == 0 {
+		err = errors.New("no complete frames")
+	}
+	if err != nil {
+		os.Remove(recoveringName)
+		return 0, err
+	}
+
+	metadata := &recorder.RecordingMetadata{
+		Recovered:    true,
+		MotionConfig: r.MotionConfig(),
+	}
+	if err := metadata.WriteFile(recordingMetadataName(finalName)); err != nil {
+		log.Printf("failed to write recovered recording metadata: %v", err)
+	}
+	if err := os.Rename(recoveringName, finalName); err != nil {
+		return 0, err
+	}
+	return frames, os.Remove(tempName)
+}
+
+// copyCompleteFrames writes the header and every frame which can be read
+// from r to w.  Reading stops at the first incomplete or corrupt frame.
+func copyCompleteFrames(r *cptv.FileReader, w *cptvFileWriter) (int, error) {
+	err := w.WriteHeader(cptv.Header{
+		Timestamp:    r.Timestamp(),
+		DeviceName:   r.DeviceName(),
+		PreviewSecs:  r.PreviewSecs(),
+		MotionConfig: r.MotionConfig(),
+		Latitude:     r.Latitude(),
+		Longitude:    r.Longitude(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	frame := new(lepton3.Frame)
+	frames := 0
+	for r.ReadFrame(frame) == nil {
+		if err := w.WriteFrame(frame); err != nil {
+			return frames, err
+		}
+		frames++
+	}
+	return frames, nil
+}
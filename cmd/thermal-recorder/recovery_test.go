@@ -0,0 +1,168 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cptv "github.com/TheCacophonyProject/go-cptv"
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/TheCacophonyProject/thermal-recorder/recorder"
+)
+
+// writeTempRecording writes a recording of noisy frames (which don't
+// compress well) and returns its size.
+func writeTempRecording(t *testing.T, filename string, frames int) int64 {
+	w, err := newCPTVFileWriter(filename, 0)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteHeader(cptv.Header{DeviceName: "test", Latitude: -43.5}))
+	frame := new(lepton3.Frame)
+	for i := 0; i < frames; i++ {
+		for y := range frame.Pix {
+			for x := range frame.Pix[y] {
+				frame.Pix[y][x] = uint16(rand.Intn(1000))
+			}
+		}
+		frame.Status.TimeOn = time.Duration(i) * time.Second
+		require.NoError(t, w.WriteFrame(frame))
+	}
+	require.NoError(t, w.Close())
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+	return info.Size()
+}
+
+func countFrames(t *testing.T, filename string) int {
+	r, err := cptv.NewFileReader(filename)
+	require.NoError(t, err)
+	defer r.Close()
+	frames, err := r.FrameCount()
+	require.NoError(t, err)
+	return frames
+}
+
+func TestRecoverTruncatedTempFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tempName := filepath.Join(dir, "20190101.120000.000."+cptvTempExt)
+	size := writeTempRecording(t, tempName, 10)
+	require.NoError(t, os.Truncate(tempName, size/2))
+
+	require.NoError(t, recoverTempFiles(dir))
+
+	finalName := filepath.Join(dir, "20190101.120000.000.cptv")
+	frames := countFrames(t, finalName)
+	assert.True(t, frames > 0 && frames < 10, "recovered %d frames", frames)
+
+	r, err := cptv.NewFileReader(finalName)
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, "test", r.DeviceName())
+	assert.Equal(t, float32(-43.5), r.Latitude())
+
+	buf, err := ioutil.ReadFile(recordingMetadataName(finalName))
+	require.NoError(t, err)
+	var metadata recorder.RecordingMetadata
+	require.NoError(t, json.Unmarshal(buf, &metadata))
+	assert.True(t, metadata.Recovered)
+
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.temp"))
+	assert.Empty(t, leftovers)
+	leftovers, _ = filepath.Glob(filepath.Join(dir, "*"+recoveringExt))
+	assert.Empty(t, leftovers)
+}
+
+func TestRecoverCompleteTempFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeTempRecording(t, filepath.Join(dir, "20190101.120000.000."+cptvTempExt), 3)
+	require.NoError(t, recoverTempFiles(dir))
+	assert.Equal(t, 3, countFrames(t, filepath.Join(dir, "20190101.120000.000.cptv")))
+}
+
+func TestUnrecoverableTempFileDeleted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tempName := filepath.Join(dir, "20190101.120000.000."+cptvTempExt)
+	require.NoError(t, ioutil.WriteFile(tempName, []byte("not a recording"), 0644))
+	require.NoError(t, recoverTempFiles(dir))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Empty(t, files)
+}
+
+func TestPartialMetadataFileDeleted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A finished recording whose metadata was being rewritten.
+	finalName := filepath.Join(dir, "20190101.120000.000.cptv")
+	writeTempRecording(t, finalName, 2)
+	metadataTemp := recordingMetadataName(finalName) + ".temp"
+	require.NoError(t, ioutil.WriteFile(metadataTemp, []byte(`{"max-sc`), 0644))
+	require.NoError(t, recoverTempFiles(dir))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{finalName}, files)
+}
+
+func TestFileWriterFlushesPeriodically(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "flushed.cptv")
+	w, err := newCPTVFileWriter(filename, time.Nanosecond)
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, w.WriteHeader(cptv.Header{}))
+	frame := new(lepton3.Frame)
+	for i := 0; i < 5; i++ {
+		for y := range frame.Pix {
+			for x := range frame.Pix[y] {
+				frame.Pix[y][x] = uint16(rand.Intn(1000))
+			}
+		}
+		require.NoError(t, w.WriteFrame(frame))
+	}
+
+	// All of the frames can be read back before the recording is closed.
+	r, err := cptv.NewFileReader(filename)
+	require.NoError(t, err)
+	defer r.Close()
+	frames := 0
+	for r.ReadFrame(frame) == nil {
+		frames++
+	}
+	assert.Equal(t, 5, frames)
+}
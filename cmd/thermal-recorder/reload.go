@@ -0,0 +1,191 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// configReloader re-reads the config files while the recorder is
+// running.  New settings are checked as soon as they are read but are
+// only applied by the frame loop, between frames.  Changes to the
+// recording pipeline wait until any recording in progress has finished.
+type configReloader struct {
+	configFile         string
+	uploaderConfigFile string
+	outputs            *outputDirs
+
+	mu      sync.Mutex
+	pending *Config
+	waiting bool
+	// The output directory when the config was reloaded, so that a
+	// change made over D-Bus since then isn't undone.
+	reloadedOutputDir string
+}
+
+func newConfigReloader(configFile, uploaderConfigFile string, outputs *outputDirs) *configReloader {
+	return &configReloader{
+		configFile:         configFile,
+		uploaderConfigFile: uploaderConfigFile,
+		outputs:            outputs,
+	}
+}
+
+// reload reads and validates the config files and queues the new
+// settings to be applied.  The config sections which changed are
+// returned.
+func (r *configReloader) reload() ([]string, error) {
+	newConf, err := ParseConfigFiles(r.configFile, r.uploaderConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	r.outputs.mu.Lock()
+	outputDir := r.outputs.conf.OutputDir
+	changed := changedSections(r.outputs.conf, newConf)
+	r.outputs.mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(changed) == 0 {
+		log.Print("config reloaded: no changes")
+		r.pending = nil
+		return changed, nil
+	}
+	log.Printf("config reloaded: %s changed", strings.Join(changed, ", "))
+	r.pending = newConf
+	r.waiting = false
+	r.reloadedOutputDir = outputDir
+	return changed, nil
+}
+
+// reloadAction is what has to be set up again for new settings to be
+// used.
+type reloadAction int
+
+const (
+	// reloadNothing is for settings which are used as they are needed
+	// (or not until the recorder is restarted).
+	reloadNothing reloadAction = iota
+	// reloadRecorder is for settings which only affect how recordings
+	// are saved.
+	reloadRecorder
+	// reloadProcessor is for settings which affect motion detection.
+	// The motion processor has to be rebuilt, losing what it has learnt
+	// about the scene.
+	reloadProcessor
+)
+
+// apply applies any reloaded settings to the running config.  If the
+// changes affect the recording pipeline they are held back while
+// recording is true.  It returns what needs to be set up again to use
+// the new settings.
+func (r *configReloader) apply(recording bool) reloadAction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		return reloadNothing
+	}
+
+	r.outputs.mu.Lock()
+	defer r.outputs.mu.Unlock()
+	conf := r.outputs.conf
+	if conf.OutputDir != r.reloadedOutputDir {
+		r.pending.OutputDir = conf.OutputDir
+	}
+	changed := changedSections(conf, r.pending)
+	if len(changed) == 0 {
+		r.pending = nil
+		return reloadNothing
+	}
+	action := requiredReload(changed)
+	if action != reloadNothing && recording {
+		if !r.waiting {
+			log.Print("waiting for recording to finish before applying config")
+			r.waiting = true
+		}
+		return reloadNothing
+	}
+
+	for _, section := range changed {
+		switch section {
+		case "frame-input":
+			log.Print("frame-input change will be used for the next camera connection")
+		case "turret":
+			log.Print("turret changes will be used after the recorder is restarted")
+		}
+	}
+	// The turret controller keeps using the settings it was started with.
+	turret := conf.Turret
+	*conf = *r.pending
+	conf.Turret = turret
+	r.pending = nil
+	r.waiting = false
+	log.Printf("config applied: %s", strings.Join(changed, ", "))
+	logConfig(conf)
+	return action
+}
+
+// configSections are the top level config sections, named as in the
+// config file, with how to get each from a Config and what has to be set
+// up again when it changes.
+var configSections = []struct {
+	name   string
+	get    func(*Config) interface{}
+	action reloadAction
+}{
+	{"device-name", func(c *Config) interface{} { return c.DeviceName }, reloadRecorder},
+	{"frame-input", func(c *Config) interface{} { return c.FrameInput }, reloadNothing},
+	{"output-dir", func(c *Config) interface{} { return c.OutputDir }, reloadRecorder},
+	{"min-disk-space", func(c *Config) interface{} { return c.MinDiskSpace }, reloadRecorder},
+	{"flush-secs", func(c *Config) interface{} { return c.FlushSecs }, reloadRecorder},
+	{"storage", func(c *Config) interface{} { return c.Storage }, reloadRecorder},
+	{"recorder", func(c *Config) interface{} { return c.Recorder }, reloadProcessor},
+	{"motion", func(c *Config) interface{} { return c.Motion }, reloadProcessor},
+	{"turret", func(c *Config) interface{} { return c.Turret }, reloadNothing},
+	{"throttler", func(c *Config) interface{} { return c.Throttler }, reloadProcessor},
+}
+
+// changedSections returns the names of the config sections which differ
+// between a and b.
+func changedSections(a, b *Config) []string {
+	changed := []string{}
+	for _, section := range configSections {
+		if !reflect.DeepEqual(section.get(a), section.get(b)) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
+
+// requiredReload returns what has to be set up again for the changed
+// sections to be used.
+func requiredReload(changed []string) reloadAction {
+	action := reloadNothing
+	for _, name := range changed {
+		for _, section := range configSections {
+			if section.name == name && section.action > action {
+				action = section.action
+			}
+		}
+	}
+	return action
+}
@@ -0,0 +1,116 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReloader(t *testing.T, config string) (*configReloader, *Config, func()) {
+	dir := tempDir(t)
+	configFile := writeConfigFile(t, dir, config)
+	uploaderFile := filepath.Join(dir, "thermal-uploader.yaml")
+	require.NoError(t, ioutil.WriteFile(uploaderFile, []byte("device-name: test\n"), 0644))
+
+	conf, err := ParseConfigFiles(configFile, uploaderFile)
+	require.NoError(t, err)
+	reloader := newConfigReloader(configFile, uploaderFile, newOutputDirs(conf, configFile))
+	return reloader, conf, func() { os.RemoveAll(dir) }
+}
+
+func TestChangedSections(t *testing.T) {
+	a := defaultConfig
+	b := defaultConfig
+	assert.Empty(t, changedSections(&a, &b))
+
+	b.Motion.TempThresh = a.Motion.TempThresh + 1
+	b.FlushSecs = 5
+	assert.Equal(t, []string{"flush-secs", "motion"}, changedSections(&a, &b))
+}
+
+func TestReloadAppliesBetweenRecordings(t *testing.T) {
+	reloader, conf, cleanup := newTestReloader(t, "recorder:\n  min-secs: 5\n")
+	defer cleanup()
+
+	writeConfigFile(t, filepath.Dir(reloader.configFile), "recorder:\n  min-secs: 7\nframe-input: /tmp/frames\n")
+	changed, err := reloader.reload()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"frame-input", "recorder"}, changed)
+
+	// The recorder change has to wait for the recording to finish.
+	assert.Equal(t, reloadNothing, reloader.apply(true))
+	assert.Equal(t, 5, conf.Recorder.MinSecs)
+
+	assert.Equal(t, reloadProcessor, reloader.apply(false))
+	assert.Equal(t, 7, conf.Recorder.MinSecs)
+	assert.Equal(t, "/tmp/frames", conf.FrameInput)
+
+	// Nothing left to apply.
+	assert.Equal(t, reloadNothing, reloader.apply(false))
+}
+
+func TestReloadWithoutPipelineChangesAppliesWhileRecording(t *testing.T) {
+	reloader, conf, cleanup := newTestReloader(t, "")
+	defer cleanup()
+
+	writeConfigFile(t, filepath.Dir(reloader.configFile), "frame-input: /tmp/frames\n")
+	_, err := reloader.reload()
+	require.NoError(t, err)
+
+	assert.Equal(t, reloadNothing, reloader.apply(true))
+	assert.Equal(t, "/tmp/frames", conf.FrameInput)
+}
+
+func TestInvalidReloadKeepsConfig(t *testing.T) {
+	reloader, conf, cleanup := newTestReloader(t, "")
+	defer cleanup()
+
+	writeConfigFile(t, filepath.Dir(reloader.configFile), "flush-secs: -1\n")
+	_, err := reloader.reload()
+	assert.Error(t, err)
+
+	assert.Equal(t, reloadNothing, reloader.apply(false))
+	assert.Equal(t, defaultConfig.FlushSecs, conf.FlushSecs)
+}
+
+func TestReloadKeepsOutputDirSetSinceReload(t *testing.T) {
+	reloader, conf, cleanup := newTestReloader(t, "flush-secs: 5\n")
+	defer cleanup()
+	newDir := tempDir(t)
+	defer os.RemoveAll(newDir)
+
+	writeConfigFile(t, filepath.Dir(reloader.configFile), "flush-secs: 7\n")
+	_, err := reloader.reload()
+	require.NoError(t, err)
+	require.NoError(t, reloader.outputs.set(newDir, false))
+
+	assert.Equal(t, reloadRecorder, reloader.apply(false))
+	assert.Equal(t, 7, conf.FlushSecs)
+	assert.Equal(t, newDir, conf.OutputDir)
+}
+
+func TestRequiredReload(t *testing.T) {
+	assert.Equal(t, reloadNothing, requiredReload([]string{"frame-input", "turret"}))
+	assert.Equal(t, reloadRecorder, requiredReload([]string{"turret", "storage", "flush-secs"}))
+	assert.Equal(t, reloadProcessor, requiredReload([]string{"storage", "motion"}))
+}
@@ -18,9 +18,12 @@ package main
 
 import (
 	"errors"
+	"time"
 
 	"github.com/godbus/dbus"
 	"github.com/godbus/dbus/introspect"
+
+	"github.com/TheCacophonyProject/thermal-recorder/motion"
 )
 
 const (
@@ -29,10 +32,11 @@ const (
 )
 
 type service struct {
-	dir string
+	outputs  *outputDirs
+	reloader *configReloader
 }
 
-func startService(dir string) error {
+func startService(outputs *outputDirs, reloader *configReloader) error {
 	conn, err := dbus.SystemBus()
 	if err != nil {
 		return err
@@ -46,7 +50,8 @@ func startService(dir string) error {
 	}
 
 	s := &service{
-		dir: dir,
+		outputs:  outputs,
+		reloader: reloader,
 	}
 	conn.Export(s, dbusPath, dbusName)
 	conn.Export(genIntrospectable(s), dbusPath, "org.freedesktop.DBus.Introspectable")
@@ -66,7 +71,7 @@ func genIntrospectable(v interface{}) introspect.Introspectable {
 
 // TakeSnapshot will save the next frame as a still
 func (s *service) TakeSnapshot() *dbus.Error {
-	err := newSnapshot(s.dir)
+	err := newSnapshot(s.outputs.dir())
 	if err != nil {
 		return &dbus.Error{
 			Name: dbusName + ".StayOnForError",
@@ -75,3 +80,102 @@ func (s *service) TakeSnapshot() *dbus.Error {
 	}
 	return nil
 }
+
+// TakeMaskSnapshot will save the next frame as a still with the pixels
+// ignored by motion detection highlighted
+func (s *service) TakeMaskSnapshot() *dbus.Error {
+	err := newMaskSnapshot(s.outputs.dir(), motion.NewMask(s.outputs.masks()))
+	if err != nil {
+		return &dbus.Error{
+			Name: dbusName + ".StayOnForError",
+			Body: []interface{}{err.Error()},
+		}
+	}
+	return nil
+}
+
+// BadPixels returns the [x, y] positions of the stuck and flickering
+// pixels which motion detection is ignoring
+func (s *service) BadPixels() ([][]int32, *dbus.Error) {
+	processor := getProcessor()
+	if processor == nil {
+		return nil, notStartedError()
+	}
+	pixels := [][]int32{}
+	for _, p := range processor.BadPixels() {
+		pixels = append(pixels, []int32{int32(p.X), int32(p.Y)})
+	}
+	return pixels, nil
+}
+
+// RecordingWindows returns today's recording windows with any times
+// relative to sunrise or sunset worked out
+func (s *service) RecordingWindows() ([]string, *dbus.Error) {
+	processor := getProcessor()
+	if processor == nil {
+		return nil, notStartedError()
+	}
+	return processor.Schedule().Today(), nil
+}
+
+// SunTimes returns today's sunrise and sunset times (RFC 3339) as used
+// by the recording windows. They are empty if no windows use them.
+func (s *service) SunTimes() (string, string, *dbus.Error) {
+	processor := getProcessor()
+	if processor == nil {
+		return "", "", notStartedError()
+	}
+	sunrise, sunset := processor.Schedule().SunTimes()
+	if sunrise.IsZero() {
+		return "", "", nil
+	}
+	return sunrise.Format(time.RFC3339), sunset.Format(time.RFC3339), nil
+}
+
+// SetOutputDir changes the directory recordings are saved in, eg. to a
+// USB drive. A recording in progress is finished and carries on in the
+// new directory. If moveRecordings is true then the recordings already
+// made are moved to the new directory too. The change is saved to the
+// config file.
+func (s *service) SetOutputDir(dir string, moveRecordings bool) *dbus.Error {
+	if err := s.outputs.set(dir, moveRecordings); err != nil {
+		return &dbus.Error{
+			Name: dbusName + ".OutputDirError",
+			Body: []interface{}{err.Error()},
+		}
+	}
+	return nil
+}
+
+// ResetOutputDir changes the directory recordings are saved in back to
+// the default.
+func (s *service) ResetOutputDir() *dbus.Error {
+	if err := s.outputs.reset(); err != nil {
+		return &dbus.Error{
+			Name: dbusName + ".OutputDirError",
+			Body: []interface{}{err.Error()},
+		}
+	}
+	return nil
+}
+
+// ReloadConfig re-reads the config files. The new settings are applied
+// between frames, after any recording in progress has finished if they
+// affect recording. The config sections which changed are returned.
+func (s *service) ReloadConfig() ([]string, *dbus.Error) {
+	changed, err := s.reloader.reload()
+	if err != nil {
+		return nil, &dbus.Error{
+			Name: dbusName + ".ConfigError",
+			Body: []interface{}{err.Error()},
+		}
+	}
+	return changed, nil
+}
+
+func notStartedError() *dbus.Error {
+	return &dbus.Error{
+		Name: dbusName + ".StayOnForError",
+		Body: []interface{}{"Reading from camera has not started yet."},
+	}
+}
@@ -27,6 +27,8 @@ import (
 	"sync"
 
 	"github.com/TheCacophonyProject/lepton3"
+
+	"github.com/TheCacophonyProject/thermal-recorder/motion"
 )
 
 var (
@@ -38,23 +40,15 @@ func newSnapshot(dir string) error {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if processor == nil {
-		return errors.New("Reading from camera has not started yet.")
-	}
-	f := processor.GetRecentFrame(new(lepton3.Frame))
-	if f == nil {
-		return errors.New("no frames yet")
+	f, err := recentFrame()
+	if err != nil {
+		return err
 	}
-	g16 := image.NewGray16(image.Rect(0, 0, lepton3.FrameCols, lepton3.FrameRows))
-	// Max and min are needed for normalization of the frame
-	var valMax uint16
-	var valMin uint16 = math.MaxUint16
+
 	var id int
 	for _, row := range f.Pix {
 		for _, val := range row {
 			id += int(val)
-			valMax = maxUint16(valMax, val)
-			valMin = minUint16(valMin, val)
 		}
 	}
 
@@ -64,19 +58,80 @@ func newSnapshot(dir string) error {
 	}
 	previousSnapshotID = id
 
-	var norm = math.MaxUint16 / (valMax - valMin)
+	g16 := image.NewGray16(image.Rect(0, 0, lepton3.FrameCols, lepton3.FrameRows))
+	valMin, norm := normalisation(f)
 	for y, row := range f.Pix {
 		for x, val := range row {
 			g16.SetGray16(x, y, color.Gray16{Y: (val - valMin) * norm})
 		}
 	}
 
-	out, err := os.Create(path.Join(dir, "still.png"))
+	return writePNG(path.Join(dir, "still.png"), g16)
+}
+
+// newMaskSnapshot saves the most recent frame with the pixels ignored by
+// motion detection tinted red so the configured masks can be checked.
+func newMaskSnapshot(dir string, mask *motion.Mask) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, err := recentFrame()
+	if err != nil {
+		return err
+	}
+
+	img := image.NewRGBA64(image.Rect(0, 0, lepton3.FrameCols, lepton3.FrameRows))
+	valMin, norm := normalisation(f)
+	for y, row := range f.Pix {
+		for x, val := range row {
+			grey := (val - valMin) * norm
+			if mask.Excluded(x, y) {
+				img.SetRGBA64(x, y, color.RGBA64{R: grey/2 + math.MaxUint16/2, G: grey / 2, B: grey / 2, A: math.MaxUint16})
+			} else {
+				img.SetRGBA64(x, y, color.RGBA64{R: grey, G: grey, B: grey, A: math.MaxUint16})
+			}
+		}
+	}
+
+	return writePNG(path.Join(dir, "still-mask.png"), img)
+}
+
+func recentFrame() (*lepton3.Frame, error) {
+	processor := getProcessor()
+	if processor == nil {
+		return nil, errors.New("Reading from camera has not started yet.")
+	}
+	f := processor.GetRecentFrame(new(lepton3.Frame))
+	if f == nil {
+		return nil, errors.New("no frames yet")
+	}
+	return f, nil
+}
+
+// normalisation returns the minimum value in the frame and the factor to
+// scale values by so they cover the full 16 bit range.
+func normalisation(f *lepton3.Frame) (uint16, uint16) {
+	var valMax uint16
+	var valMin uint16 = math.MaxUint16
+	for _, row := range f.Pix {
+		for _, val := range row {
+			valMax = maxUint16(valMax, val)
+			valMin = minUint16(valMin, val)
+		}
+	}
+	if valMax == valMin {
+		return valMin, 0
+	}
+	return valMin, math.MaxUint16 / (valMax - valMin)
+}
+
+func writePNG(filename string, img image.Image) error {
+	out, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
-	return png.Encode(out, g16)
+	return png.Encode(out, img)
 }
 
 func maxUint16(a, b uint16) uint16 {
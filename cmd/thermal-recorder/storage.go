@@ -0,0 +1,238 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/TheCacophonyProject/thermal-recorder/events"
+)
+
+// Which recordings are removed first when the output directory is full.
+const (
+	evictOldest      = "oldest"
+	evictLowestScore = "lowest-score"
+)
+
+// Why a recording was removed.
+const (
+	evictedForMaxFiles     = "max-files"
+	evictedForMaxSize      = "max-size"
+	evictedForMinDiskSpace = "min-disk-space"
+)
+
+type StorageConfig struct {
+	MaxSizeMB uint64 `yaml:"max-size-mb"`
+	MaxFiles  int    `yaml:"max-files"`
+	Evict     string `yaml:"evict"`
+}
+
+func (conf *StorageConfig) enabled() bool {
+	return conf.MaxSizeMB > 0 || conf.MaxFiles > 0
+}
+
+func (conf *StorageConfig) validate() error {
+	if conf.MaxFiles < 0 {
+		return fmt.Errorf("storage max-files can not be negative")
+	}
+	switch conf.Evict {
+	case evictOldest, evictLowestScore:
+		return nil
+	}
+	return fmt.Errorf("unknown storage evict policy %q", conf.Evict)
+}
+
+// storage keeps the finished recordings in a directory within a quota
+// by removing recordings (and their metadata files) to make room for
+// new ones.  Recordings are also removed when the disk is short of
+// space.
+//
+// Looking through the recordings takes a while so it is done in the
+// background, after each recording is finished, rather than as a
+// recording is about to start.
+type storage struct {
+	conf        StorageConfig
+	diskSpaceOK func() (bool, error)
+	onEvict     func(filename, reason string)
+
+	// mu stops recordings being removed by more than one goroutine at a
+	// time, and protects dir which changes with the output directory.
+	mu  sync.Mutex
+	dir string
+}
+
+// newStorage returns nil if there is no storage quota.
+func newStorage(dir string, conf StorageConfig, minDiskSpace uint64) *storage {
+	if !conf.enabled() {
+		return nil
+	}
+	s := &storage{
+		dir:  dir,
+		conf: conf,
+		onEvict: func(filename, reason string) {
+			go events.Queue("recording-evicted", map[string]interface{}{
+				"file":   filename,
+				"reason": reason,
+			})
+		},
+	}
+	// Only called by makeRoom so s.dir is safe to use.
+	s.diskSpaceOK = func() (bool, error) {
+		return checkDiskSpace(minDiskSpace, s.dir)
+	}
+	return s
+}
+
+type storedRecording struct {
+	filename string
+	size     int64
+	score    float64
+}
+
+// recordings returns the finished recordings in the order they should
+// be removed.
+func (s *storage) recordings() ([]storedRecording, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.cptv"))
+	if err != nil {
+		return nil, err
+	}
+	// Recording names start with the time they were made.
+	sort.Strings(matches)
+
+	var recordings []storedRecording
+	for _, filename := range matches {
+		info, err := os.Stat(filename)
+		if err != nil {
+			continue
+		}
+		r := storedRecording{filename: filename, size: info.Size()}
+		if info, err := os.Stat(recordingMetadataName(filename)); err == nil {
+			r.size += info.Size()
+		}
+		if s.conf.Evict == evictLowestScore {
+			r.score = recordingScore(filename)
+		}
+		recordings = append(recordings, r)
+	}
+	if s.conf.Evict == evictLowestScore {
+		sort.SliceStable(recordings, func(i, j int) bool {
+			return recordings[i].score < recordings[j].score
+		})
+	}
+	return recordings, nil
+}
+
+// recordingScore returns the highest motion score in a recording, or 0
+// if it isn't known.
+func recordingScore(filename string) float64 {
+	buf, err := ioutil.ReadFile(recordingMetadataName(filename))
+	if err != nil {
+		return 0
+	}
+	var metadata struct {
+		MaxScore float64 `json:"max-score"`
+	}
+	if err := json.Unmarshal(buf, &metadata); err != nil {
+		return 0
+	}
+	return metadata.MaxScore
+}
+
+// setDir changes the directory the recordings are kept in.
+func (s *storage) setDir(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dir = dir
+}
+
+// makeRoomLater removes recordings in the background until there is room
+// for another one.
+func (s *storage) makeRoomLater() {
+	go func() {
+		if err := s.makeRoom(); err != nil {
+			log.Printf("failed to make room for recordings: %v", err)
+		}
+	}()
+}
+
+// makeRoom removes recordings until there is room for another one.
+func (s *storage) makeRoom() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordings, err := s.recordings()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, r := range recordings {
+		total += r.size
+	}
+
+	for len(recordings) > 0 {
+		reason, err := s.overLimit(len(recordings), total)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			break
+		}
+		r := recordings[0]
+		if err := s.evict(r.filename, reason); err != nil {
+			return err
+		}
+		recordings = recordings[1:]
+		total -= r.size
+	}
+	return nil
+}
+
+// overLimit returns why there isn't room for another recording, or an
+// empty string if there is.
+func (s *storage) overLimit(files int, size int64) (string, error) {
+	if s.conf.MaxFiles > 0 && files >= s.conf.MaxFiles {
+		return evictedForMaxFiles, nil
+	}
+	if s.conf.MaxSizeMB > 0 && uint64(size) >= s.conf.MaxSizeMB*1024*1024 {
+		return evictedForMaxSize, nil
+	}
+	enoughSpace, err := s.diskSpaceOK()
+	if err != nil {
+		return "", err
+	}
+	if !enoughSpace {
+		return evictedForMinDiskSpace, nil
+	}
+	return "", nil
+}
+
+func (s *storage) evict(filename, reason string) error {
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+	os.Remove(recordingMetadataName(filename))
+	log.Printf("removed %s to make room for new recordings (%s)", filename, reason)
+	s.onEvict(filepath.Base(filename), reason)
+	return nil
+}
@@ -0,0 +1,145 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type evictedRecording struct {
+	filename string
+	reason   string
+}
+
+func newTestStorage(t *testing.T, conf StorageConfig) (*storage, *[]evictedRecording, func()) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	evicted := new([]evictedRecording)
+	s := &storage{
+		dir:         dir,
+		conf:        conf,
+		diskSpaceOK: func() (bool, error) { return true, nil },
+		onEvict: func(filename, reason string) {
+			*evicted = append(*evicted, evictedRecording{filename, reason})
+		},
+	}
+	return s, evicted, func() { os.RemoveAll(dir) }
+}
+
+// addRecording adds a recording of size bytes with a metadata file
+// holding its score.
+func addRecording(t *testing.T, s *storage, name string, size int, score float64) {
+	filename := filepath.Join(s.dir, name+".cptv")
+	require.NoError(t, ioutil.WriteFile(filename, make([]byte, size), 0644))
+	metadata := fmt.Sprintf(`{"max-score": %f}`, score)
+	require.NoError(t, ioutil.WriteFile(recordingMetadataName(filename), []byte(metadata), 0644))
+}
+
+func remaining(s *storage) []string {
+	matches, _ := filepath.Glob(filepath.Join(s.dir, "*"))
+	var names []string
+	for _, m := range matches {
+		names = append(names, filepath.Base(m))
+	}
+	return names
+}
+
+func TestNoStorageWithoutLimits(t *testing.T) {
+	assert.Nil(t, newStorage("/tmp", StorageConfig{Evict: evictOldest}, 200))
+}
+
+func TestEvictOldestForMaxFiles(t *testing.T) {
+	s, evicted, cleanup := newTestStorage(t, StorageConfig{MaxFiles: 2, Evict: evictOldest})
+	defer cleanup()
+	addRecording(t, s, "20190103.120000.000", 10, 1)
+	addRecording(t, s, "20190101.120000.000", 10, 5)
+	addRecording(t, s, "20190102.120000.000", 10, 3)
+
+	require.NoError(t, s.makeRoom())
+	assert.Equal(t, []evictedRecording{
+		{"20190101.120000.000.cptv", evictedForMaxFiles},
+		{"20190102.120000.000.cptv", evictedForMaxFiles},
+	}, *evicted)
+	assert.Equal(t, []string{"20190103.120000.000.cptv", "20190103.120000.000.json"}, remaining(s))
+}
+
+func TestEvictLowestScoreForMaxSize(t *testing.T) {
+	s, evicted, cleanup := newTestStorage(t, StorageConfig{MaxSizeMB: 1, Evict: evictLowestScore})
+	defer cleanup()
+	addRecording(t, s, "20190101.120000.000", 400*1024, 5)
+	addRecording(t, s, "20190102.120000.000", 400*1024, 1)
+	addRecording(t, s, "20190103.120000.000", 400*1024, 3)
+
+	require.NoError(t, s.makeRoom())
+	assert.Equal(t, []evictedRecording{
+		{"20190102.120000.000.cptv", evictedForMaxSize},
+	}, *evicted)
+}
+
+func TestEvictForMinDiskSpace(t *testing.T) {
+	s, evicted, cleanup := newTestStorage(t, StorageConfig{MaxFiles: 10, Evict: evictOldest})
+	defer cleanup()
+	addRecording(t, s, "20190101.120000.000", 10, 1)
+	addRecording(t, s, "20190102.120000.000", 10, 1)
+	checks := 0
+	s.diskSpaceOK = func() (bool, error) {
+		checks++
+		return checks > 1, nil
+	}
+
+	require.NoError(t, s.makeRoom())
+	assert.Equal(t, []evictedRecording{
+		{"20190101.120000.000.cptv", evictedForMinDiskSpace},
+	}, *evicted)
+}
+
+func TestMakeRoomLater(t *testing.T) {
+	s, _, cleanup := newTestStorage(t, StorageConfig{MaxFiles: 1, Evict: evictOldest})
+	defer cleanup()
+	addRecording(t, s, "20190101.120000.000", 10, 1)
+	evicted := make(chan string, 1)
+	s.onEvict = func(filename, reason string) {
+		evicted <- filename
+	}
+
+	s.makeRoomLater()
+	select {
+	case filename := <-evicted:
+		assert.Equal(t, "20190101.120000.000.cptv", filename)
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording wasn't removed")
+	}
+}
+
+func TestTempFilesNotEvicted(t *testing.T) {
+	s, evicted, cleanup := newTestStorage(t, StorageConfig{MaxFiles: 1, Evict: evictOldest})
+	defer cleanup()
+	tempName := filepath.Join(s.dir, "20190101.120000.000."+cptvTempExt)
+	require.NoError(t, ioutil.WriteFile(tempName, []byte("recording"), 0644))
+
+	require.NoError(t, s.makeRoom())
+	assert.Empty(t, *evicted)
+	assert.Equal(t, []string{"20190101.120000.000.cptv.temp"}, remaining(s))
+}
@@ -0,0 +1,63 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package events queues events with the Cacophony event reporter over
+// D-Bus so that they are uploaded along with the recordings.
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// Queue uses the event api to record that something happened now.
+// Details are optional.  Failures are logged rather than returned as
+// there is nothing the caller can do about them.
+func Queue(eventType string, details map[string]interface{}) {
+	ts := time.Now()
+	detailsJSON, err := eventJSON(eventType, details)
+	if err != nil {
+		log.Printf("Could not record %s event: %s", eventType, err)
+		return
+	}
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		log.Printf("Could not record %s event: %s", eventType, err)
+		return
+	}
+
+	obj := conn.Object("org.cacophony.Events", "/org/cacophony/Events")
+	call := obj.Call("org.cacophony.Events.Queue", 0, detailsJSON, ts.UnixNano())
+	if call.Err != nil {
+		log.Printf("Could not record %s event: %s", eventType, call.Err)
+	}
+}
+
+func eventJSON(eventType string, details map[string]interface{}) ([]byte, error) {
+	description := map[string]interface{}{
+		"type": eventType,
+	}
+	if details != nil {
+		description["details"] = details
+	}
+	return json.Marshal(map[string]interface{}{
+		"description": description,
+	})
+}
@@ -0,0 +1,36 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventJSON(t *testing.T) {
+	buf, err := eventJSON("throttle", nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"description": {"type": "throttle"}}`, string(buf))
+}
+
+func TestEventJSONWithDetails(t *testing.T) {
+	buf, err := eventJSON("scene-change", map[string]interface{}{"changed-pixels": 4000})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"description": {"type": "scene-change", "details": {"changed-pixels": 4000}}}`, string(buf))
+}
@@ -0,0 +1,263 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+// BackgroundDetector is the name of the detector which compares each
+// frame with a learnt model of the background.
+const BackgroundDetector = "background"
+
+// Pixels whose variance is very low (eg a uniformly cold sky) would
+// otherwise flag motion on the smallest amount of sensor noise.
+const backgroundMinStdDev = 5
+
+func NewBackgroundDetector(conf MotionConfig) *backgroundDetector {
+	d := new(backgroundDetector)
+	d.learningRate = float32(conf.BackgroundLearningRate)
+	d.sigma = float32(conf.BackgroundSigma)
+	d.maxFreezeFrames = conf.BackgroundMaxFreezeSecs * lepton3.FramesHz
+	d.tempThresh = conf.TempThresh
+	d.zones = newZoneMap(conf)
+	d.celsius = newCelsiusThresholds(conf)
+	d.ffcPause = conf.FFCPeriod
+	if conf.FFCCompensation {
+		d.ffcPause = conf.FFCSettle
+	}
+	d.warmerOnly = conf.WarmerOnly
+	d.verbose = conf.Verbose
+	d.start = conf.EdgePixels
+	d.columnStop = lepton3.FrameCols - conf.EdgePixels
+	d.rowStop = lepton3.FrameRows - conf.EdgePixels
+	d.mask = *NewMask(conf.Masks)
+	d.scene = newSceneChange(&conf, &d.mask, d.start, d.rowStop, d.columnStop)
+	return d
+}
+
+// backgroundDetector keeps a running mean and variance for every pixel
+// and flags pixels which are more than sigma standard deviations away
+// from their mean.  The model isn't updated where tracked objects are so
+// that an animal which stops moving isn't learnt into the background.
+// Objects tracked for longer than maxFreezeFrames are learnt anyway so
+// that something new which stays put (eg a parked car) doesn't keep
+// triggering recordings.
+//
+// Detection pauses after an FFC, and the overall shift in readings it
+// causes is then removed from the model so what has been learnt is kept.
+// Scene changes are handled the same way when they are subtracted.
+type backgroundDetector struct {
+	mean            [lepton3.FrameRows][lepton3.FrameCols]float32
+	variance        [lepton3.FrameRows][lepton3.FrameCols]float32
+	learningRate    float32
+	sigma           float32
+	maxFreezeFrames int
+	initialised     bool
+	tempThresh      uint16
+	zones           zoneMap
+	celsius         *celsiusThresholds
+	ffcPause        time.Duration
+	ffcPending      bool
+	warmerOnly      bool
+	verbose         bool
+	start           int
+	rowStop         int
+	columnStop      int
+	mask            Mask
+	scene           *sceneChange
+	frozen          pixelMask
+	changed         pixelMask
+	finder          regionFinder
+}
+
+func (d *backgroundDetector) Detect(frame *lepton3.Frame) Result {
+	if d.celsius != nil {
+		d.tempThresh, _, _ = d.celsius.update(frame, d.tempThresh, 0)
+	}
+
+	if frame.Status.FFCState == lepton3.FFCRunning || isAffectedByFFC(frame, d.ffcPause) {
+		d.ffcPending = d.initialised
+		return Result{}
+	}
+	if !d.initialised {
+		d.reset(frame)
+		return Result{}
+	}
+	if d.ffcPending {
+		d.ffcPending = false
+		offset := d.shift(frame)
+		if d.verbose {
+			log.Printf("FFC offset %.1f", offset)
+		}
+	}
+
+	deltaCount := d.classify(frame)
+	if d.scene != nil && d.scene.isGlobal(deltaCount) && d.scene.subtract {
+		// Remove the overall shift in the scene and try again.
+		offset := d.shift(frame)
+		deltaCount = d.classify(frame)
+		if d.verbose {
+			log.Printf("Scene change offset %.1f", offset)
+		}
+	}
+	d.learn(frame)
+
+	if d.scene != nil && d.scene.isGlobal(deltaCount) {
+		if d.verbose {
+			log.Printf("Scene change suppressed, deltaCount %d", deltaCount)
+		}
+		return Result{
+			ChangedPixels: deltaCount,
+			SceneChange:   true,
+		}
+	}
+
+	result := Result{
+		Motion:        d.zones.triggered(),
+		Score:         float64(deltaCount),
+		ChangedPixels: deltaCount,
+	}
+	if deltaCount > 0 {
+		result.Regions = d.finder.find(&d.changed, frame, d.start, d.rowStop, d.columnStop)
+	}
+	return result
+}
+
+// FollowTracks freezes the background model under the tracked objects,
+// apart from those which have been tracked for too long.
+func (d *backgroundDetector) FollowTracks(tracks []*Track) {
+	d.frozen = pixelMask{}
+	for _, track := range tracks {
+		if d.maxFreezeFrames > 0 && track.EndFrame-track.StartFrame >= d.maxFreezeFrames {
+			continue
+		}
+		r := track.Region
+		for y := max(r.Top-1, d.start); y <= min(r.Bottom+1, d.rowStop-1); y++ {
+			for x := max(r.Left-1, d.start); x <= min(r.Right+1, d.columnStop-1); x++ {
+				d.frozen[y][x] = true
+			}
+		}
+	}
+}
+
+func (d *backgroundDetector) floor(v uint16) float32 {
+	if v < d.tempThresh {
+		return float32(d.tempThresh)
+	}
+	return float32(v)
+}
+
+func (d *backgroundDetector) reset(frame *lepton3.Frame) {
+	for y := d.start; y < d.rowStop; y++ {
+		for x := d.start; x < d.columnStop; x++ {
+			d.mean[y][x] = d.floor(frame.Pix[y][x])
+			d.variance[y][x] = backgroundMinStdDev * backgroundMinStdDev
+		}
+	}
+	d.frozen = pixelMask{}
+	d.initialised = true
+}
+
+// shift moves the mean of every pixel by the difference between the
+// average of frame and the average of the model, returning the
+// difference.
+func (d *backgroundDetector) shift(frame *lepton3.Frame) float32 {
+	var frameSum, meanSum float64
+	var count int
+	for y := d.start; y < d.rowStop; y++ {
+		for x := d.start; x < d.columnStop; x++ {
+			if d.mask.excluded[y][x] {
+				continue
+			}
+			frameSum += float64(d.floor(frame.Pix[y][x]))
+			meanSum += float64(d.mean[y][x])
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	offset := float32((frameSum - meanSum) / float64(count))
+	for y := d.start; y < d.rowStop; y++ {
+		for x := d.start; x < d.columnStop; x++ {
+			d.mean[y][x] = float32(math.Max(float64(d.mean[y][x]+offset), float64(d.tempThresh)))
+		}
+	}
+	return offset
+}
+
+// classify marks the pixels in frame which differ from the background,
+// returning how many there are.
+func (d *backgroundDetector) classify(frame *lepton3.Frame) int {
+	var deltaCount int
+	d.zones.resetCounts()
+	for y := d.start; y < d.rowStop; y++ {
+		for x := d.start; x < d.columnStop; x++ {
+			if d.mask.excluded[y][x] {
+				d.changed[y][x] = false
+				continue
+			}
+			diff := d.floor(frame.Pix[y][x]) - d.mean[y][x]
+			deviation := diff
+			if deviation < 0 {
+				if d.warmerOnly {
+					deviation = 0
+				} else {
+					deviation = -deviation
+				}
+			}
+
+			stdDev := float32(math.Sqrt(float64(d.variance[y][x])))
+			if stdDev < backgroundMinStdDev {
+				stdDev = backgroundMinStdDev
+			}
+			d.changed[y][x] = deviation > d.sigma*stdDev
+			if d.changed[y][x] {
+				deltaCount++
+				d.zones.at(x, y).count++
+			}
+		}
+	}
+
+	if deltaCount > 0 && d.verbose {
+		log.Printf("deltaCount %d", deltaCount)
+	}
+	return deltaCount
+}
+
+// learn updates the model with frame, apart from where it is frozen.
+func (d *backgroundDetector) learn(frame *lepton3.Frame) {
+	for y := d.start; y < d.rowStop; y++ {
+		for x := d.start; x < d.columnStop; x++ {
+			if d.mask.excluded[y][x] || d.frozen[y][x] {
+				continue
+			}
+			diff := d.floor(frame.Pix[y][x]) - d.mean[y][x]
+			d.mean[y][x] += d.learningRate * diff
+			// Changed pixels would inflate the variance and hide the
+			// object causing the change.
+			if !d.changed[y][x] {
+				d.variance[y][x] += d.learningRate * (diff*diff - d.variance[y][x])
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func backgroundTestDetector() *backgroundDetector {
+	config := defaultMotionParams()
+	config.CountThresh = 4
+	config.BackgroundLearningRate = 0.1
+	config.BackgroundSigma = 4
+	return NewBackgroundDetector(config)
+}
+
+func spotFrame(gen *frameGen, background, x, y, offset int) *lepton3.Frame {
+	frame := gen.setupFrame(background)
+	for sy := y; sy < y+3; sy++ {
+		for sx := x; sx < x+3; sx++ {
+			frame.Pix[sy][sx] = uint16(background + offset)
+		}
+	}
+	return frame
+}
+
+func TestBackgroundNoMotionInStaticScene(t *testing.T) {
+	detector := backgroundTestDetector()
+	gen := newFrameGen(nil)
+
+	for i := 0; i < 20; i++ {
+		assert.False(t, detector.Detect(gen.setupFrame(3300)).Motion)
+	}
+}
+
+func TestBackgroundDetectsWarmObject(t *testing.T) {
+	detector := backgroundTestDetector()
+	gen := newFrameGen(nil)
+	for i := 0; i < 20; i++ {
+		detector.Detect(gen.setupFrame(3300))
+	}
+
+	result := detector.Detect(spotFrame(gen, 3300, 30, 40, 200))
+	assert.True(t, result.Motion)
+	assert.Equal(t, 9, result.ChangedPixels)
+	require.Len(t, result.Regions, 1)
+	assert.Equal(t, 31.0, result.Regions[0].CentroidX)
+	assert.Equal(t, 41.0, result.Regions[0].CentroidY)
+}
+
+func TestBackgroundIgnoresGradualWarming(t *testing.T) {
+	detector := backgroundTestDetector()
+	gen := newFrameGen(nil)
+
+	for i := 0; i < 200; i++ {
+		result := detector.Detect(gen.setupFrame(3300 + i))
+		if !assert.False(t, result.Motion, "frame %d", i) {
+			return
+		}
+	}
+}
+
+func TestBackgroundFrozenUnderTrackedObject(t *testing.T) {
+	// Without tracks a stationary object is eventually learnt into the background.
+	detector := backgroundTestDetector()
+	gen := newFrameGen(nil)
+	detector.Detect(gen.setupFrame(3300))
+	for i := 0; i < 50; i++ {
+		detector.Detect(spotFrame(gen, 3300, 30, 40, 200))
+	}
+	assert.False(t, detector.Detect(spotFrame(gen, 3300, 30, 40, 200)).Motion)
+
+	// When the object is tracked, it is not learnt.
+	detector = backgroundTestDetector()
+	gen = newFrameGen(nil)
+	detector.Detect(gen.setupFrame(3300))
+	tracker := NewTracker(10, 9, 0)
+	for i := 0; i < 50; i++ {
+		result := detector.Detect(spotFrame(gen, 3300, 30, 40, 200))
+		tracker.Update(i, result.Regions)
+		detector.FollowTracks(tracker.Active())
+	}
+	assert.True(t, detector.Detect(spotFrame(gen, 3300, 30, 40, 200)).Motion)
+}
+
+func TestBackgroundLearnsLongTrackedObject(t *testing.T) {
+	config := defaultMotionParams()
+	config.CountThresh = 4
+	config.BackgroundLearningRate = 0.1
+	config.BackgroundSigma = 4
+	config.BackgroundMaxFreezeSecs = 2
+	detector := NewBackgroundDetector(config)
+	gen := newFrameGen(nil)
+	detector.Detect(gen.setupFrame(3300))
+
+	// A warm object which stays put is tracked until the freeze runs
+	// out and is then learnt into the background.
+	tracker := NewTracker(10, 9, 0)
+	motionFrames := 0
+	for i := 0; i < 200; i++ {
+		result := detector.Detect(spotFrame(gen, 3300, 30, 40, 200))
+		if result.Motion {
+			motionFrames++
+		}
+		tracker.Update(i, result.Regions)
+		detector.FollowTracks(tracker.Active())
+	}
+	assert.True(t, motionFrames > 2*lepton3.FramesHz, "motion for %d frames", motionFrames)
+	assert.True(t, motionFrames < 100, "motion for %d frames", motionFrames)
+	assert.False(t, detector.Detect(spotFrame(gen, 3300, 30, 40, 200)).Motion)
+}
+
+func TestBackgroundKeptThroughFFC(t *testing.T) {
+	detector := backgroundTestDetector()
+	gen := newFrameGen(nil)
+	for i := 0; i < 5; i++ {
+		detector.Detect(gen.setupFrame(3300))
+	}
+
+	// Detection pauses after the FFC and the model is moved by the
+	// shift in readings, so the object there all along isn't learnt.
+	gen.FFC()
+	for i := 0; i <= 10*lepton3.FramesHz; i++ {
+		assert.False(t, detector.Detect(spotFrame(gen, 3500, 30, 40, 200)).Motion)
+	}
+	assert.True(t, detector.Detect(spotFrame(gen, 3500, 30, 40, 200)).Motion)
+}
+
+func TestBackgroundFFCCompensationPausesForSettle(t *testing.T) {
+	config := defaultMotionParams()
+	config.CountThresh = 4
+	config.BackgroundLearningRate = 0.1
+	config.BackgroundSigma = 4
+	config.FFCCompensation = true
+	detector := NewBackgroundDetector(config)
+	gen := newFrameGen(nil)
+	for i := 0; i < 5; i++ {
+		detector.Detect(gen.setupFrame(3300))
+	}
+
+	gen.FFC()
+	for i := 0; i <= lepton3.FramesHz; i++ {
+		assert.False(t, detector.Detect(spotFrame(gen, 3500, 30, 40, 200)).Motion)
+	}
+	assert.True(t, detector.Detect(spotFrame(gen, 3500, 30, 40, 200)).Motion)
+}
@@ -0,0 +1,251 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+// A pixel which flips by more than delta-thresh this many times more
+// often than its neighbours is considered to be flickering.
+const badPixelFlickerFactor = 10
+
+// BadPixel is the position of a stuck or flickering pixel.
+type BadPixel struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type badPixelFile struct {
+	Pixels []BadPixel `json:"pixels"`
+}
+
+type pixelCounts [lepton3.FrameRows][lepton3.FrameCols]int32
+
+// badPixelMap learns which pixels are stuck (never change while their
+// neighbours do) or flicker (jump far more often than their neighbours)
+// and replaces them with the average of their good neighbours before
+// motion detection.  The map is relearnt every period and saved to file
+// so it is available straight away after a restart.
+type badPixelMap struct {
+	mu           sync.Mutex
+	pixels       []BadPixel
+	bad          pixelMask
+	file         string
+	periodFrames int
+	deltaThresh  uint16
+	verbose      bool
+
+	frames   int
+	previous [lepton3.FrameRows][lepton3.FrameCols]uint16
+	changes  pixelCounts
+	flips    pixelCounts
+	repaired lepton3.Frame
+}
+
+// newBadPixelMap returns nil if bad pixel detection is turned off.
+func newBadPixelMap(conf *MotionConfig) *badPixelMap {
+	if !conf.BadPixelDetection {
+		return nil
+	}
+	m := &badPixelMap{
+		file:         conf.BadPixelFile,
+		periodFrames: int(conf.BadPixelPeriod.Seconds() * lepton3.FramesHz),
+		deltaThresh:  conf.DeltaThresh,
+		verbose:      conf.Verbose,
+	}
+	if m.file != "" {
+		pixels, err := loadBadPixels(m.file)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to load bad pixels: %v", err)
+		}
+		m.setPixels(pixels)
+		if len(pixels) > 0 {
+			log.Printf("loaded %d bad pixels from %s", len(pixels), m.file)
+		}
+	}
+	return m
+}
+
+// Pixels returns the pixels currently considered bad.
+func (m *badPixelMap) Pixels() []BadPixel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]BadPixel(nil), m.pixels...)
+}
+
+func (m *badPixelMap) setPixels(pixels []BadPixel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pixels = pixels
+	m.bad = pixelMask{}
+	for _, p := range pixels {
+		m.bad[p.Y][p.X] = true
+	}
+}
+
+// learn updates the statistics used to find bad pixels with frame.
+func (m *badPixelMap) learn(frame *lepton3.Frame) {
+	if m.frames > 0 {
+		for y := 0; y < lepton3.FrameRows; y++ {
+			for x := 0; x < lepton3.FrameCols; x++ {
+				diff := absDiff(frame.Pix[y][x], m.previous[y][x])
+				if diff > 0 {
+					m.changes[y][x]++
+				}
+				if diff > m.deltaThresh {
+					m.flips[y][x]++
+				}
+			}
+		}
+	}
+	m.previous = frame.Pix
+	m.frames++
+
+	if m.frames > m.periodFrames {
+		m.update(m.find())
+		m.frames = 0
+		m.changes = pixelCounts{}
+		m.flips = pixelCounts{}
+	}
+}
+
+// find returns the pixels which behaved badly over the last period.
+func (m *badPixelMap) find() []BadPixel {
+	var pixels []BadPixel
+	for y := 0; y < lepton3.FrameRows; y++ {
+		for x := 0; x < lepton3.FrameCols; x++ {
+			stuck := m.changes[y][x] == 0 && neighbourMean(&m.changes, x, y) > float64(m.frames/4)
+			flips := float64(m.flips[y][x])
+			flickering := flips > float64(m.frames/100) &&
+				flips > badPixelFlickerFactor*(neighbourMean(&m.flips, x, y)+1)
+			if stuck || flickering {
+				pixels = append(pixels, BadPixel{X: x, Y: y})
+			}
+		}
+	}
+	return pixels
+}
+
+func (m *badPixelMap) update(pixels []BadPixel) {
+	old := m.Pixels()
+	if equalBadPixels(old, pixels) {
+		return
+	}
+	log.Printf("bad pixels changed from %d to %d: %v", len(old), len(pixels), pixels)
+	m.setPixels(pixels)
+	if m.file != "" {
+		if err := saveBadPixels(m.file, pixels); err != nil {
+			log.Printf("failed to save bad pixels: %v", err)
+		}
+	}
+}
+
+// repair returns frame with the bad pixels replaced by the mean of their
+// good neighbours.  frame is returned unchanged if there are no bad
+// pixels.
+// Note: The returned frame will be rewritten next time repair is called.
+func (m *badPixelMap) repair(frame *lepton3.Frame) *lepton3.Frame {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.pixels) == 0 {
+		return frame
+	}
+	m.repaired = *frame
+	for _, p := range m.pixels {
+		var sum, count int
+		for y := max(p.Y-1, 0); y <= min(p.Y+1, lepton3.FrameRows-1); y++ {
+			for x := max(p.X-1, 0); x <= min(p.X+1, lepton3.FrameCols-1); x++ {
+				if !m.bad[y][x] {
+					sum += int(frame.Pix[y][x])
+					count++
+				}
+			}
+		}
+		if count > 0 {
+			m.repaired.Pix[p.Y][p.X] = uint16(sum / count)
+		}
+	}
+	return &m.repaired
+}
+
+func neighbourMean(counts *pixelCounts, x, y int) float64 {
+	var sum, count int
+	for ny := max(y-1, 0); ny <= min(y+1, lepton3.FrameRows-1); ny++ {
+		for nx := max(x-1, 0); nx <= min(x+1, lepton3.FrameCols-1); nx++ {
+			if nx != x || ny != y {
+				sum += int(counts[ny][nx])
+				count++
+			}
+		}
+	}
+	return float64(sum) / float64(count)
+}
+
+func equalBadPixels(a, b []BadPixel) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func loadBadPixels(filename string) ([]BadPixel, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var f badPixelFile
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return nil, err
+	}
+	var pixels []BadPixel
+	for _, p := range f.Pixels {
+		if p.X >= 0 && p.X < lepton3.FrameCols && p.Y >= 0 && p.Y < lepton3.FrameRows {
+			pixels = append(pixels, p)
+		}
+	}
+	return pixels, nil
+}
+
+// saveBadPixels writes to a temporary file first so a partially written
+// file is never loaded.
+func saveBadPixels(filename string, pixels []BadPixel) error {
+	buf, err := json.Marshal(&badPixelFile{Pixels: pixels})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	tempName := filename + ".tmp"
+	if err := ioutil.WriteFile(tempName, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempName, filename)
+}
@@ -0,0 +1,104 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func badPixelConfig(file string) *MotionConfig {
+	config := defaultMotionParams()
+	config.BadPixelDetection = true
+	config.BadPixelPeriod = 10 * time.Second
+	config.BadPixelFile = file
+	return &config
+}
+
+// noisyFrame returns a frame where every pixel changes a little between
+// frames, except for a stuck pixel at (5, 5) and a pixel at (20, 10)
+// which jumps every few frames.
+func noisyFrame(i int) *lepton3.Frame {
+	frame := new(lepton3.Frame)
+	for y := 0; y < lepton3.FrameRows; y++ {
+		for x := 0; x < lepton3.FrameCols; x++ {
+			frame.Pix[y][x] = uint16(3300 + (x+y+i)%4)
+		}
+	}
+	frame.Pix[5][5] = 3300
+	if i%4 == 0 {
+		frame.Pix[10][20] = 3600
+	}
+	return frame
+}
+
+func TestBadPixelsDisabledByDefault(t *testing.T) {
+	config := DefaultMotionConfig()
+	assert.Nil(t, newBadPixelMap(&config))
+}
+
+func TestBadPixelsLearnt(t *testing.T) {
+	m := newBadPixelMap(badPixelConfig(""))
+	for i := 0; i < m.periodFrames; i++ {
+		m.learn(noisyFrame(i))
+		assert.Empty(t, m.Pixels())
+	}
+	m.learn(noisyFrame(m.periodFrames))
+	assert.Equal(t, []BadPixel{{X: 5, Y: 5}, {X: 20, Y: 10}}, m.Pixels())
+}
+
+func TestBadPixelsRepaired(t *testing.T) {
+	m := newBadPixelMap(badPixelConfig(""))
+	frame := new(lepton3.Frame)
+	assert.True(t, frame == m.repair(frame))
+
+	m.setPixels([]BadPixel{{X: 0, Y: 0}, {X: 1, Y: 0}})
+	frame.Pix[0][0] = 9000
+	frame.Pix[0][1] = 9000
+	frame.Pix[1][0] = 3000
+	frame.Pix[1][1] = 3100
+	frame.Pix[1][2] = 3200
+	frame.Pix[0][2] = 3300
+
+	repaired := m.repair(frame)
+	assert.Equal(t, uint16(3050), repaired.Pix[0][0])
+	assert.Equal(t, uint16(3150), repaired.Pix[0][1])
+	assert.Equal(t, uint16(9000), frame.Pix[0][0])
+}
+
+func TestBadPixelsSavedAndLoaded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bad-pixels")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "sub", "bad-pixels.json")
+
+	m := newBadPixelMap(badPixelConfig(file))
+	for i := 0; i <= m.periodFrames; i++ {
+		m.learn(noisyFrame(i))
+	}
+	require.Len(t, m.Pixels(), 2)
+
+	loaded := newBadPixelMap(badPixelConfig(file))
+	assert.Equal(t, m.Pixels(), loaded.Pixels())
+}
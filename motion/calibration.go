@@ -0,0 +1,123 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"errors"
+	"log"
+	"math"
+
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+// Calibration converts between raw Lepton values and degrees Celsius.
+// A scene at ReferenceTempC reads as ReferenceCounts when the camera's
+// focal plane array (FPA) is at ReferenceFPATempC.  Readings then shift by
+// FPACountsPerDegree for every degree the FPA temperature moves away from
+// the reference.
+type Calibration struct {
+	CountsPerDegree    float64 `yaml:"counts-per-degree"`
+	ReferenceCounts    float64 `yaml:"reference-counts"`
+	ReferenceTempC     float64 `yaml:"reference-temp-c"`
+	ReferenceFPATempC  float64 `yaml:"reference-fpa-temp-c"`
+	FPACountsPerDegree float64 `yaml:"fpa-counts-per-degree"`
+}
+
+func DefaultCalibration() Calibration {
+	return Calibration{
+		CountsPerDegree:   30,
+		ReferenceCounts:   3000,
+		ReferenceTempC:    10,
+		ReferenceFPATempC: 25,
+	}
+}
+
+func (c *Calibration) Validate() error {
+	if c.CountsPerDegree <= 0 {
+		return errors.New("calibration counts-per-degree must be greater than zero")
+	}
+	return nil
+}
+
+// ToCounts returns the raw value expected for a scene at tempC when the
+// FPA is at fpaTempC.
+func (c *Calibration) ToCounts(tempC, fpaTempC float64) float64 {
+	return c.ReferenceCounts +
+		c.CountsPerDegree*(tempC-c.ReferenceTempC) +
+		c.FPACountsPerDegree*(fpaTempC-c.ReferenceFPATempC)
+}
+
+// DeltaToCounts returns the change in raw value for a change in scene
+// temperature of deltaC.
+func (c *Calibration) DeltaToCounts(deltaC float64) float64 {
+	return c.CountsPerDegree * deltaC
+}
+
+// celsiusThresholds works out the raw temperature and delta thresholds
+// from thresholds configured in degrees Celsius.  The values are only
+// recalculated when the FPA temperature changes.
+type celsiusThresholds struct {
+	tempC       *float64
+	deltaC      *float64
+	calibration Calibration
+	fpaTempC    float64
+	calculated  bool
+	verbose     bool
+}
+
+func newCelsiusThresholds(conf MotionConfig) *celsiusThresholds {
+	if conf.TempThreshC == nil && conf.DeltaThreshC == nil {
+		return nil
+	}
+	return &celsiusThresholds{
+		tempC:       conf.TempThreshC,
+		deltaC:      conf.DeltaThreshC,
+		calibration: conf.Calibration,
+		verbose:     conf.Verbose,
+	}
+}
+
+// update returns the raw thresholds for the frame.  Thresholds which
+// aren't configured in Celsius are returned unchanged.  The returned bool
+// is true if the thresholds changed.
+func (ct *celsiusThresholds) update(frame *lepton3.Frame, temp, delta uint16) (uint16, uint16, bool) {
+	fpaTempC := frame.Status.TempC
+	if fpaTempC == 0 {
+		// No telemetry (eg CPTV playback).
+		fpaTempC = ct.calibration.ReferenceFPATempC
+	}
+	if ct.calculated && fpaTempC == ct.fpaTempC {
+		return temp, delta, false
+	}
+	ct.calculated = true
+	ct.fpaTempC = fpaTempC
+
+	if ct.tempC != nil {
+		temp = toUint16(ct.calibration.ToCounts(*ct.tempC, fpaTempC))
+	}
+	if ct.deltaC != nil {
+		delta = toUint16(ct.calibration.DeltaToCounts(*ct.deltaC))
+	}
+	if ct.verbose {
+		log.Printf("FPA temperature %.2fC: temp-thresh %d, delta-thresh %d", fpaTempC, temp, delta)
+	}
+	return temp, delta, true
+}
+
+func toUint16(v float64) uint16 {
+	return uint16(math.Max(0, math.Min(math.MaxUint16, math.Round(v))))
+}
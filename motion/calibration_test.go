@@ -0,0 +1,80 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCalibration() Calibration {
+	return Calibration{
+		CountsPerDegree:    30,
+		ReferenceCounts:    3000,
+		ReferenceTempC:     10,
+		ReferenceFPATempC:  25,
+		FPACountsPerDegree: -4,
+	}
+}
+
+func TestCalibrationToCounts(t *testing.T) {
+	c := testCalibration()
+	assert.Equal(t, 3000.0, c.ToCounts(10, 25))
+	assert.Equal(t, 3150.0, c.ToCounts(15, 25))
+	assert.Equal(t, 3110.0, c.ToCounts(15, 35))
+	assert.Equal(t, 45.0, c.DeltaToCounts(1.5))
+}
+
+func TestCelsiusThresholdsFollowFPATemperature(t *testing.T) {
+	tempC := 15.0
+	deltaC := 1.0
+	config := defaultMotionParams()
+	config.TempThreshC = &tempC
+	config.DeltaThreshC = &deltaC
+	config.Calibration = testCalibration()
+	detector := NewMotionDetector(config)
+
+	frame := newFrameGen(detector).setupFrame(3300)
+	frame.Status.TempC = 25
+	detector.Detect(frame)
+	assert.Equal(t, uint16(3150), detector.tempThresh)
+	assert.Equal(t, uint16(30), detector.zones.defaultDeltaThresh())
+
+	frame.Status.TempC = 30
+	detector.Detect(frame)
+	assert.Equal(t, uint16(3130), detector.tempThresh)
+}
+
+func TestCelsiusThresholdsUseReferenceWithoutTelemetry(t *testing.T) {
+	tempC := 15.0
+	config := defaultMotionParams()
+	config.TempThreshC = &tempC
+	config.Calibration = testCalibration()
+	detector := NewMotionDetector(config)
+
+	detector.Detect(newFrameGen(detector).setupFrame(3300))
+	assert.Equal(t, uint16(3150), detector.tempThresh)
+	assert.Equal(t, config.DeltaThresh, detector.zones.defaultDeltaThresh())
+}
+
+func TestCelsiusThresholdsNeedCalibration(t *testing.T) {
+	deltaC := 1.0
+	config := defaultMotionParams()
+	config.DeltaThreshC = &deltaC
+	assert.EqualError(t, config.Validate(), "calibration counts-per-degree must be greater than zero")
+}
@@ -0,0 +1,100 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+// FrameDiffDetector is the name of the detector which compares each frame
+// with one recorded frame-compare-gap frames earlier.
+const FrameDiffDetector = "frame-diff"
+
+// Detector looks for motion in a stream of frames.  Detect is called
+// once for every frame received from the camera, in order.
+type Detector interface {
+	Detect(frame *lepton3.Frame) Result
+}
+
+// Result holds what a Detector found in a single frame.  DeltaThresh is
+// the delta threshold which was used, if the detector has one.
+// SceneChange is set when motion was suppressed because most of the
+// scene changed at once.
+// Note: Regions may be rewritten when the next frame is processed.
+type Result struct {
+	Motion        bool
+	Score         float64
+	ChangedPixels int
+	Regions       []Region
+	DeltaThresh   uint16
+	SceneChange   bool
+}
+
+// TrackFollower is implemented by detectors which want to know where the
+// currently tracked objects are.  FollowTracks is called after each frame
+// has been processed.
+type TrackFollower interface {
+	FollowTracks(tracks []*Track)
+}
+
+// DetectorFactory creates a Detector configured from conf.
+type DetectorFactory func(conf MotionConfig) Detector
+
+var detectors = map[string]DetectorFactory{
+	FrameDiffDetector:  func(conf MotionConfig) Detector { return NewMotionDetector(conf) },
+	BackgroundDetector: func(conf MotionConfig) Detector { return NewBackgroundDetector(conf) },
+}
+
+// RegisterDetector makes a detector available for selection using the
+// motion detector config option.
+func RegisterDetector(name string, factory DetectorFactory) {
+	detectors[name] = factory
+}
+
+// DetectorNames returns the names of all registered detectors.
+func DetectorNames() []string {
+	names := make([]string, 0, len(detectors))
+	for name := range detectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewDetector creates the detector selected by conf.Detector.  The
+// frame-diff detector is used if no detector is selected.
+func NewDetector(conf MotionConfig) (Detector, error) {
+	factory, err := detectorFactory(conf.Detector)
+	if err != nil {
+		return nil, err
+	}
+	return factory(conf), nil
+}
+
+func detectorFactory(name string) (DetectorFactory, error) {
+	if name == "" {
+		name = FrameDiffDetector
+	}
+	factory, ok := detectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown motion detector %q (available: %v)", name, DetectorNames())
+	}
+	return factory, nil
+}
@@ -0,0 +1,61 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type alwaysDetector struct {
+	frames int
+}
+
+func (d *alwaysDetector) Detect(frame *lepton3.Frame) Result {
+	d.frames++
+	return Result{Motion: true, Score: 1, ChangedPixels: 1}
+}
+
+func TestDefaultsToFrameDiffDetector(t *testing.T) {
+	detector, err := NewDetector(MotionConfig{})
+	require.NoError(t, err)
+	assert.IsType(t, &motionDetector{}, detector)
+}
+
+func TestUnknownDetector(t *testing.T) {
+	config := MotionConfig{Detector: "magic"}
+	_, err := NewDetector(config)
+	assert.EqualError(t, err, `unknown motion detector "magic" (available: [background frame-diff])`)
+	assert.Equal(t, err, config.Validate())
+}
+
+func TestProcessorUsesSelectedDetector(t *testing.T) {
+	always := new(alwaysDetector)
+	RegisterDetector("always", func(MotionConfig) Detector { return always })
+	defer delete(detectors, "always")
+
+	config := MotionTestConfig()
+	config.Detector = "always"
+	recorder, scenarioMaker := SetupTest(config, RecorderTestConfig())
+
+	scenarioMaker.AddBackgroundFrames(5)
+	assert.Equal(t, 5, always.frames)
+	assert.True(t, recorder.IsRecording())
+}
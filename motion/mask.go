@@ -0,0 +1,149 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+const (
+	MaskExclude = "exclude"
+	MaskInclude = "include"
+)
+
+// Shape is an area of the frame given either as a rectangle
+// ([left, top, right, bottom], inclusive) or as a polygon (a list of
+// [x, y] corners).  Coordinates are in frame pixels.
+type Shape struct {
+	Rect    []int   `yaml:"rect"`
+	Polygon [][]int `yaml:"polygon"`
+}
+
+// MaskConfig describes an area of the frame which is excluded from motion
+// detection or, if Mode is "include", the only area in which motion
+// detection happens.
+type MaskConfig struct {
+	Name  string `yaml:"name"`
+	Mode  string `yaml:"mode"`
+	Shape `yaml:",inline"`
+}
+
+func (s *Shape) Validate() error {
+	if len(s.Rect) > 0 && len(s.Polygon) > 0 {
+		return errors.New("only one of rect or polygon can be set")
+	}
+	if len(s.Rect) > 0 {
+		if len(s.Rect) != 4 {
+			return errors.New("rect must be [left, top, right, bottom]")
+		}
+		if s.Rect[0] > s.Rect[2] || s.Rect[1] > s.Rect[3] {
+			return errors.New("rect left/top must not be after right/bottom")
+		}
+		return nil
+	}
+	if len(s.Polygon) > 0 {
+		if len(s.Polygon) < 3 {
+			return errors.New("polygon must have at least 3 points")
+		}
+		for _, p := range s.Polygon {
+			if len(p) != 2 {
+				return errors.New("polygon points must be [x, y]")
+			}
+		}
+		return nil
+	}
+	return errors.New("one of rect or polygon must be set")
+}
+
+// Contains returns true if the pixel at (x, y) is inside the shape.  A
+// pixel is inside a polygon if its centre is.
+func (s *Shape) Contains(x, y int) bool {
+	if len(s.Rect) == 4 {
+		return x >= s.Rect[0] && x <= s.Rect[2] && y >= s.Rect[1] && y <= s.Rect[3]
+	}
+
+	// Even-odd rule.
+	px, py := float64(x)+0.5, float64(y)+0.5
+	inside := false
+	j := len(s.Polygon) - 1
+	for i := range s.Polygon {
+		xi, yi := float64(s.Polygon[i][0]), float64(s.Polygon[i][1])
+		xj, yj := float64(s.Polygon[j][0]), float64(s.Polygon[j][1])
+		if (yi > py) != (yj > py) && px < (xj-xi)*(py-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+func (m *MaskConfig) Validate() error {
+	if m.Mode != "" && m.Mode != MaskExclude && m.Mode != MaskInclude {
+		return fmt.Errorf("mask %q: mode must be %q or %q", m.Name, MaskExclude, MaskInclude)
+	}
+	if err := m.Shape.Validate(); err != nil {
+		return fmt.Errorf("mask %q: %v", m.Name, err)
+	}
+	return nil
+}
+
+// NewMask combines the configured masks into a bitmap of the pixels to
+// ignore.  If there are include masks, only pixels inside them are used.
+// Exclude masks are then applied on top.
+func NewMask(masks []MaskConfig) *Mask {
+	m := new(Mask)
+
+	hasInclude := false
+	for _, conf := range masks {
+		if conf.Mode == MaskInclude {
+			hasInclude = true
+		}
+	}
+
+	for y := 0; y < lepton3.FrameRows; y++ {
+		for x := 0; x < lepton3.FrameCols; x++ {
+			included := !hasInclude
+			excluded := false
+			for _, conf := range masks {
+				if !conf.Contains(x, y) {
+					continue
+				}
+				if conf.Mode == MaskInclude {
+					included = true
+				} else {
+					excluded = true
+				}
+			}
+			m.excluded[y][x] = excluded || !included
+		}
+	}
+	return m
+}
+
+// Mask is a bitmap of the pixels which are ignored by motion detection.
+// The zero value ignores nothing.
+type Mask struct {
+	excluded pixelMask
+}
+
+// Excluded returns true if the pixel at (x, y) is ignored.
+func (m *Mask) Excluded(x, y int) bool {
+	return m.excluded[y][x]
+}
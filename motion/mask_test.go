@@ -0,0 +1,92 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRectContainsIsInclusive(t *testing.T) {
+	s := Shape{Rect: []int{10, 20, 12, 21}}
+	assert.True(t, s.Contains(10, 20))
+	assert.True(t, s.Contains(12, 21))
+	assert.False(t, s.Contains(9, 20))
+	assert.False(t, s.Contains(13, 21))
+	assert.False(t, s.Contains(10, 22))
+}
+
+func TestPolygonContains(t *testing.T) {
+	s := Shape{Polygon: [][]int{{0, 0}, {10, 0}, {0, 10}}}
+	assert.True(t, s.Contains(1, 1))
+	assert.True(t, s.Contains(8, 0))
+	assert.False(t, s.Contains(9, 1))
+	assert.False(t, s.Contains(11, 0))
+}
+
+func TestExcludeMask(t *testing.T) {
+	mask := NewMask([]MaskConfig{
+		{Name: "post", Shape: Shape{Rect: []int{5, 5, 6, 6}}},
+	})
+	assert.True(t, mask.Excluded(5, 5))
+	assert.True(t, mask.Excluded(6, 6))
+	assert.False(t, mask.Excluded(7, 6))
+	assert.False(t, mask.Excluded(0, 0))
+}
+
+func TestIncludeMask(t *testing.T) {
+	mask := NewMask([]MaskConfig{
+		{Name: "ground", Mode: MaskInclude, Shape: Shape{Rect: []int{0, 60, lepton3.FrameCols - 1, lepton3.FrameRows - 1}}},
+		{Name: "road", Shape: Shape{Rect: []int{0, 100, 20, 110}}},
+	})
+	assert.True(t, mask.Excluded(10, 10))
+	assert.False(t, mask.Excluded(10, 60))
+	assert.True(t, mask.Excluded(10, 105))
+	assert.False(t, mask.Excluded(30, 105))
+}
+
+func TestMaskValidation(t *testing.T) {
+	m := MaskConfig{Name: "bad", Mode: "sideways", Shape: Shape{Rect: []int{1, 1, 2, 2}}}
+	assert.EqualError(t, m.Validate(), `mask "bad": mode must be "exclude" or "include"`)
+
+	m = MaskConfig{Name: "empty"}
+	assert.EqualError(t, m.Validate(), `mask "empty": one of rect or polygon must be set`)
+
+	m = MaskConfig{Name: "both", Shape: Shape{Rect: []int{1, 1, 2, 2}, Polygon: [][]int{{0, 0}, {1, 0}, {0, 1}}}}
+	assert.EqualError(t, m.Validate(), `mask "both": only one of rect or polygon can be set`)
+
+	m = MaskConfig{Name: "line", Shape: Shape{Polygon: [][]int{{0, 0}, {1, 0}}}}
+	assert.EqualError(t, m.Validate(), `mask "line": polygon must have at least 3 points`)
+
+	m = MaskConfig{Name: "short", Shape: Shape{Rect: []int{1, 1, 2}}}
+	assert.EqualError(t, m.Validate(), `mask "short": rect must be [left, top, right, bottom]`)
+}
+
+func TestNoMotionDetectedInExcludedArea(t *testing.T) {
+	config := defaultMotionParams()
+	config.UseOneDiffOnly = true
+	config.Masks = []MaskConfig{
+		{Name: "spot", Shape: Shape{Rect: []int{10, 10, 30, 30}}},
+	}
+	detector := NewMotionDetector(config)
+
+	detects, pixels := newFrameGen(detector).Movement(5)
+	assertAllFalse(t, detects)
+	assertAllZero(t, pixels)
+}
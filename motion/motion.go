@@ -23,11 +23,6 @@ import (
 	"github.com/TheCacophonyProject/lepton3"
 )
 
-// This is the period for which measurements go funny after a Flat
-// Field Correction.
-// TODO - this should probably be configurable (although 10s does seem right).
-const ffcPeriod = 10 * time.Second
-
 func NewMotionDetector(args MotionConfig) *motionDetector {
 
 	d := new(motionDetector)
@@ -35,53 +30,112 @@ func NewMotionDetector(args MotionConfig) *motionDetector {
 	d.diffFrames = *NewFrameLoop(2)
 	d.useOneDiff = args.UseOneDiffOnly
 	d.framesGap = uint64(args.FrameCompareGap)
-	d.deltaThresh = args.DeltaThresh
-	d.countThresh = args.CountThresh
+	d.zones = newZoneMap(args)
+	d.celsius = newCelsiusThresholds(args)
+	d.noise = newNoiseEstimator(args)
+	d.ffcPeriod = args.FFCPeriod
+	d.ffcCompensation = args.FFCCompensation
+	d.ffcSettle = args.FFCSettle
 	d.tempThresh = args.TempThresh
 	d.verbose = args.Verbose
 	d.warmerOnly = args.WarmerOnly
 	d.start = args.EdgePixels
 	d.columnStop = lepton3.FrameCols - args.EdgePixels
 	d.rowStop = lepton3.FrameRows - args.EdgePixels
+	d.mask = *NewMask(args.Masks)
+	d.scene = newSceneChange(&args, &d.mask, d.start, d.rowStop, d.columnStop)
 
 	return d
 }
 
 type motionDetector struct {
-	flooredFrames FrameLoop
-	diffFrames    FrameLoop
-	firstDiff     bool
-	useOneDiff    bool
-	tempThresh    uint16
-	deltaThresh   uint16
-	countThresh   int
-	framesGap     uint64
-	verbose       bool
-	warmerOnly    bool
-	start         int
-	rowStop       int
-	columnStop    int
-}
-
-func (d *motionDetector) Detect(frame *lepton3.Frame) bool {
-	movement, _ := d.pixelsChanged(frame)
-	return movement
+	flooredFrames   FrameLoop
+	diffFrames      FrameLoop
+	adjustedFrame   lepton3.Frame
+	ffcPeriod       time.Duration
+	ffcCompensation bool
+	ffcSettle       time.Duration
+	firstDiff       bool
+	useOneDiff      bool
+	tempThresh      uint16
+	zones           zoneMap
+	celsius         *celsiusThresholds
+	noise           *noiseEstimator
+	framesGap       uint64
+	verbose         bool
+	warmerOnly      bool
+	start           int
+	rowStop         int
+	columnStop      int
+	mask            Mask
+	scene           *sceneChange
+	sceneChanged    bool
+	changed         pixelMask
+	finder          regionFinder
+	regions         []Region
+}
+
+func (d *motionDetector) Detect(frame *lepton3.Frame) Result {
+	movement, deltaCount := d.pixelsChanged(frame)
+	score := float64(deltaCount)
+	if d.sceneChanged {
+		score = 0
+	}
+	return Result{
+		Motion:        movement,
+		Score:         score,
+		ChangedPixels: deltaCount,
+		Regions:       d.regions,
+		DeltaThresh:   d.zones.defaultDeltaThresh(),
+		SceneChange:   d.sceneChanged,
+	}
+}
+
+// Regions returns the groups of changed pixels found in the most
+// recently processed frame.
+// Note: The returned slice will be rewritten when the next frame is processed.
+func (d *motionDetector) Regions() []Region {
+	return d.regions
 }
 
 func (d *motionDetector) pixelsChanged(frame *lepton3.Frame) (bool, int) {
+	d.regions = nil
+	d.sceneChanged = false
+
+	if d.celsius != nil {
+		temp, delta, changed := d.celsius.update(frame, d.tempThresh, d.zones.defaultDeltaThresh())
+		if changed {
+			d.tempThresh = temp
+			d.zones.setDefaultDeltaThresh(delta)
+		}
+	}
+
+	// Frames captured while the camera is settling after an FFC aren't
+	// kept so later frames are compared with frames from before the FFC.
+	if d.ffcCompensation && d.isSettlingAfterFFC(frame) {
+		return false, 0
+	}
+
 	processedFrame := d.flooredFrames.Current()
-	d.setFloor(frame, processedFrame)
+	d.setFloor(frame, processedFrame, 0)
 
 	// we will compare with the oldest saved frame.
 	compareFrame := d.flooredFrames.Oldest()
 	defer d.flooredFrames.Move()
 
-	diffFrame := d.diffFrames.Current()
-	if d.warmerOnly {
-		d.warmerDiffFrames(processedFrame, compareFrame, diffFrame)
-	} else {
-		d.absDiffFrames(processedFrame, compareFrame, diffFrame)
+	currentFrame := processedFrame
+	if d.ffcCompensation && compareFrame.Status.LastFFCTime != frame.Status.LastFFCTime {
+		// An FFC happened between the frames, which shifts all readings
+		// by roughly the same amount.  Remove the shift before comparing.
+		offset := int32(processedFrame.Status.FrameMean) - int32(compareFrame.Status.FrameMean)
+		currentFrame = d.setFloor(frame, &d.adjustedFrame, offset)
+		if d.verbose {
+			log.Printf("FFC offset %d", offset)
+		}
 	}
+
+	diffFrame := d.diffFrames.Current()
+	d.diffFrame(currentFrame, compareFrame, diffFrame)
 	prevDiffFrame := d.diffFrames.Move()
 
 	if !d.firstDiff {
@@ -89,32 +143,77 @@ func (d *motionDetector) pixelsChanged(frame *lepton3.Frame) (bool, int) {
 		return false, 0
 	}
 
-	if isAffectedByFFC(frame) {
+	if !d.ffcCompensation && isAffectedByFFC(frame, d.ffcPeriod) {
 		d.flooredFrames.SetAsOldest()
 		d.firstDiff = false
 		return false, 0
 	}
 
-	if d.useOneDiff {
-		return d.hasMotion(diffFrame, nil)
+	if d.noise != nil {
+		// d.changed still holds the pixels which moved in the previous frame.
+		thresh := d.noise.update(currentFrame, compareFrame, d.tempThresh, &d.changed, d.start, d.rowStop, d.columnStop)
+		d.zones.setDefaultDeltaThresh(thresh)
+	}
+
+	movement, deltaCount := d.hasMotion(diffFrame, prevDiffFrame)
+
+	if d.scene != nil && d.scene.isGlobal(deltaCount) {
+		if d.scene.subtract {
+			// Remove the overall shift in the scene and try again.
+			offset := int32(processedFrame.Status.FrameMean) - int32(compareFrame.Status.FrameMean)
+			currentFrame = d.setFloor(frame, &d.adjustedFrame, offset)
+			d.diffFrame(currentFrame, compareFrame, diffFrame)
+			movement, deltaCount = d.hasMotion(diffFrame, prevDiffFrame)
+			if d.verbose {
+				log.Printf("Scene change offset %d", offset)
+			}
+		}
+		if d.scene.isGlobal(deltaCount) {
+			if d.verbose {
+				log.Printf("Scene change suppressed, deltaCount %d", deltaCount)
+			}
+			d.sceneChanged = true
+			return false, deltaCount
+		}
 	}
-	return d.hasMotion(diffFrame, prevDiffFrame)
+
+	if deltaCount > 0 {
+		d.regions = d.finder.find(&d.changed, frame, d.start, d.rowStop, d.columnStop)
+	}
+	return movement, deltaCount
 }
 
-func isAffectedByFFC(f *lepton3.Frame) bool {
-	return f.Status.TimeOn-f.Status.LastFFCTime < ffcPeriod
+func isAffectedByFFC(f *lepton3.Frame, period time.Duration) bool {
+	return f.Status.TimeOn-f.Status.LastFFCTime < period
 }
 
-func (d *motionDetector) setFloor(f, out *lepton3.Frame) *lepton3.Frame {
+func (d *motionDetector) isSettlingAfterFFC(f *lepton3.Frame) bool {
+	return f.Status.FFCState == lepton3.FFCRunning || isAffectedByFFC(f, d.ffcSettle)
+}
+
+// setFloor copies f to out after subtracting offset, raising values below
+// tempThresh (and masked pixels) to tempThresh.  The frame's telemetry is
+// copied too.  If the camera didn't provide the frame mean it is
+// calculated from the area used for motion detection.
+func (d *motionDetector) setFloor(f, out *lepton3.Frame, offset int32) *lepton3.Frame {
+	var sum, count int
+	thresh := int32(d.tempThresh)
 	for y := d.start; y < d.rowStop; y++ {
 		for x := d.start; x < d.columnStop; x++ {
-			v := f.Pix[y][x]
-			if v < d.tempThresh {
+			sum += int(f.Pix[y][x])
+			v := int32(f.Pix[y][x]) - offset
+			if v < thresh || d.mask.excluded[y][x] {
 				out.Pix[y][x] = d.tempThresh
 			} else {
-				out.Pix[y][x] = v
+				out.Pix[y][x] = uint16(v)
 			}
 		}
+		count += d.columnStop - d.start
+	}
+
+	out.Status = f.Status
+	if out.Status.FrameMean == 0 && count > 0 {
+		out.Status.FrameMean = uint16(sum / count)
 	}
 	return out
 }
@@ -125,8 +224,11 @@ func (d *motionDetector) CountPixelsTwoCompare(f1 *lepton3.Frame, f2 *lepton3.Fr
 		for x := d.start; x < d.columnStop; x++ {
 			v1 := f1.Pix[y][x]
 			v2 := f2.Pix[y][x]
-			if (v1 > d.deltaThresh) && (v2 > d.deltaThresh) {
+			zone := d.zones.at(x, y)
+			d.changed[y][x] = (v1 > zone.deltaThresh) && (v2 > zone.deltaThresh) && !d.mask.excluded[y][x]
+			if d.changed[y][x] {
 				deltaCount++
+				zone.count++
 			}
 		}
 	}
@@ -138,11 +240,14 @@ func (d *motionDetector) CountPixels(f1 *lepton3.Frame) (deltas int) {
 	for y := d.start; y < d.rowStop; y++ {
 		for x := d.start; x < d.columnStop; x++ {
 			v1 := f1.Pix[y][x]
-			if v1 > d.deltaThresh {
+			zone := d.zones.at(x, y)
+			d.changed[y][x] = v1 > zone.deltaThresh && !d.mask.excluded[y][x]
+			if d.changed[y][x] {
 				if d.verbose {
 					log.Printf("Motion (%d, %d) = %d", x, y, v1)
 				}
 				deltaCount++
+				zone.count++
 			}
 		}
 	}
@@ -151,6 +256,7 @@ func (d *motionDetector) CountPixels(f1 *lepton3.Frame) (deltas int) {
 
 func (d *motionDetector) hasMotion(f1 *lepton3.Frame, f2 *lepton3.Frame) (bool, int) {
 	var deltaCount int
+	d.zones.resetCounts()
 	if d.useOneDiff {
 		deltaCount = d.CountPixels(f1)
 	} else {
@@ -159,14 +265,30 @@ func (d *motionDetector) hasMotion(f1 *lepton3.Frame, f2 *lepton3.Frame) (bool,
 
 	if deltaCount > 0 && d.verbose {
 		log.Printf("deltaCount %d", deltaCount)
+		if len(d.zones.zones) > 1 {
+			for _, z := range d.zones.zones {
+				log.Printf("zone %s: %d/%d", z.name, z.count, z.countThresh)
+			}
+		}
 	}
-	return deltaCount >= d.countThresh, deltaCount
+	return d.zones.triggered(), deltaCount
+}
+
+func (d *motionDetector) diffFrame(a, b, out *lepton3.Frame) *lepton3.Frame {
+	if d.warmerOnly {
+		return d.warmerDiffFrames(a, b, out)
+	}
+	return d.absDiffFrames(a, b, out)
 }
 
 func (d *motionDetector) absDiffFrames(a, b, out *lepton3.Frame) *lepton3.Frame {
 	for y := d.start; y < d.rowStop; y++ {
 		for x := d.start; x < d.columnStop; x++ {
-			out.Pix[y][x] = absDiff(a.Pix[y][x], b.Pix[y][x])
+			if d.mask.excluded[y][x] {
+				out.Pix[y][x] = 0
+			} else {
+				out.Pix[y][x] = absDiff(a.Pix[y][x], b.Pix[y][x])
+			}
 		}
 	}
 	return out
@@ -175,7 +297,11 @@ func (d *motionDetector) absDiffFrames(a, b, out *lepton3.Frame) *lepton3.Frame
 func (d *motionDetector) warmerDiffFrames(a, b, out *lepton3.Frame) *lepton3.Frame {
 	for y := d.start; y < d.rowStop; y++ {
 		for x := d.start; x < d.columnStop; x++ {
-			out.Pix[y][x] = warmerDiff(a.Pix[y][x], b.Pix[y][x])
+			if d.mask.excluded[y][x] {
+				out.Pix[y][x] = 0
+			} else {
+				out.Pix[y][x] = warmerDiff(a.Pix[y][x], b.Pix[y][x])
+			}
 		}
 	}
 	return out
@@ -36,6 +36,77 @@ func TestRevertsToUsingSmallerFrameIntervalWhenNotEnoughFrames_OneFrame(t *testi
 	assert.Equal(t, []int{0, 9, 9, 9, 18}, pixels)
 }
 
+func TestSceneChangeSuppressed(t *testing.T) {
+	config := defaultMotionParams()
+	config.UseOneDiffOnly = true
+	config.SceneChangeFraction = 0.5
+	config.SceneChangeAction = SceneChangeSuppress
+	detector := NewMotionDetector(config)
+
+	gen := newFrameGen(detector)
+	gen.NoMovement(6)
+
+	// The sun comes out, warming the whole scene.
+	result := detector.Detect(gen.makeSpot(3400, 20, 500))
+	assert.False(t, result.Motion)
+	assert.True(t, result.SceneChange)
+	assert.Empty(t, result.Regions)
+}
+
+func TestSceneChangeSubtracted(t *testing.T) {
+	config := defaultMotionParams()
+	config.UseOneDiffOnly = true
+	config.SceneChangeFraction = 0.5
+	config.SceneChangeAction = SceneChangeSubtract
+	detector := NewMotionDetector(config)
+
+	gen := newFrameGen(detector)
+	gen.NoMovement(6)
+
+	// The warm spot is still found once the scene's shift is removed.
+	result := detector.Detect(gen.makeSpot(3400, 20, 500))
+	assert.True(t, result.Motion)
+	assert.False(t, result.SceneChange)
+	assert.Equal(t, 9, result.ChangedPixels)
+}
+
+func TestFFCCompensation(t *testing.T) {
+	config := defaultMotionParams()
+	config.UseOneDiffOnly = true
+	config.CountThresh = 4
+	config.FFCCompensation = true
+	detector := NewMotionDetector(config)
+
+	gen := newFrameGen(detector)
+
+	// Fill frame loop.
+	detects, pixels := gen.NoMovement(6)
+	assertAllFalse(t, detects)
+	assertAllZero(t, pixels)
+
+	// The FFC shifts the whole frame by more than the delta threshold.
+	gen.FFC()
+
+	// Frames are ignored while the camera settles.
+	for i := 0; i < lepton3.FramesHz; i++ {
+		detect, count := detector.pixelsChanged(gen.makeSpot(3400, 10+i, i*100))
+		assert.False(t, detect)
+		assert.Zero(t, count)
+	}
+
+	// The shift itself isn't reported as motion...
+	for i := 0; i < 3; i++ {
+		detect, count := detector.pixelsChanged(gen.makeSpot(3400, 0, 0))
+		assert.False(t, detect)
+		assert.Zero(t, count)
+	}
+
+	// ...but something moving is, well before the blackout would end.
+	detect, count := detector.pixelsChanged(gen.makeSpot(3400, 20, 500))
+	assert.True(t, detect)
+	assert.Equal(t, 9, count)
+}
+
 func TestNoMotionDetectedIfNothingHasChanged(t *testing.T) {
 	config := defaultMotionParams()
 	config.UseOneDiffOnly = true
@@ -160,6 +231,8 @@ func defaultMotionParams() MotionConfig {
 		FrameCompareGap: 3,
 		WarmerOnly:      false,
 		EdgePixels:      1,
+		FFCPeriod:       10 * time.Second,
+		FFCSettle:       time.Second,
 	}
 }
 
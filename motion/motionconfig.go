@@ -16,33 +16,167 @@
 
 package motion
 
+import (
+	"errors"
+	"time"
+)
+
 type MotionConfig struct {
+	Detector        string `yaml:"detector"`
 	TempThresh      uint16 `yaml:"temp-thresh"`
 	DeltaThresh     uint16 `yaml:"delta-thresh"`
 	CountThresh     int    `yaml:"count-thresh"`
 	FrameCompareGap int    `yaml:"frame-compare-gap"`
 	UseOneDiffOnly  bool   `yaml:"one-diff-only"`
 	TriggerFrames   int    `yaml:"trigger-frames"`
+	TriggerWindow   int    `yaml:"trigger-window"`
 	WarmerOnly      bool   `yaml:"warmer-only"`
 	EdgePixels      int    `yaml:"edge-pixels"`
 	Verbose         bool   `yaml:"verbose"`
+
+	StartScore    float64 `yaml:"start-score"`
+	ContinueScore float64 `yaml:"continue-score"`
+
+	TrackMaxDistance     float64 `yaml:"track-max-distance"`
+	TrackMaxMissedFrames int     `yaml:"track-max-missed-frames"`
+	TrackMaxPath         int     `yaml:"track-max-path"`
+
+	BackgroundLearningRate  float64 `yaml:"background-learning-rate"`
+	BackgroundSigma         float64 `yaml:"background-sigma"`
+	BackgroundMaxFreezeSecs int     `yaml:"background-max-freeze-secs"`
+
+	TempThreshC  *float64    `yaml:"temp-thresh-c"`
+	DeltaThreshC *float64    `yaml:"delta-thresh-c"`
+	Calibration  Calibration `yaml:"calibration"`
+
+	AdaptiveDelta   bool    `yaml:"adaptive-delta"`
+	NoiseMultiplier float64 `yaml:"noise-multiplier"`
+	DeltaThreshMin  uint16  `yaml:"delta-thresh-min"`
+	DeltaThreshMax  uint16  `yaml:"delta-thresh-max"`
+
+	ObjectMinArea            int     `yaml:"object-min-area"`
+	ObjectMaxArea            int     `yaml:"object-max-area"`
+	ObjectMaxAspectRatio     float64 `yaml:"object-max-aspect-ratio"`
+	ObjectMinDisplacement    float64 `yaml:"object-min-displacement"`
+	ObjectDisplacementFrames int     `yaml:"object-displacement-frames"`
+
+	SceneChangeFraction float64 `yaml:"scene-change-fraction"`
+	SceneChangeAction   string  `yaml:"scene-change-action"`
+
+	BadPixelDetection bool          `yaml:"bad-pixel-detection"`
+	BadPixelPeriod    time.Duration `yaml:"bad-pixel-period"`
+	BadPixelFile      string        `yaml:"bad-pixel-file"`
+
+	FFCPeriod       time.Duration `yaml:"ffc-period"`
+	FFCCompensation bool          `yaml:"ffc-compensation"`
+	FFCSettle       time.Duration `yaml:"ffc-settle"`
+
+	Masks []MaskConfig `yaml:"masks"`
+	Zones []ZoneConfig `yaml:"zones"`
 }
 
 func DefaultMotionConfig() MotionConfig {
 	return MotionConfig{
+		Detector:        FrameDiffDetector,
 		TempThresh:      2900,
 		DeltaThresh:     50,
 		CountThresh:     3,
 		FrameCompareGap: 45,
 		Verbose:         false,
 		TriggerFrames:   2,
+		TriggerWindow:   0,
 		UseOneDiffOnly:  true,
 		WarmerOnly:      true,
 		EdgePixels:      1,
+
+		StartScore:    0,
+		ContinueScore: 0,
+
+		TrackMaxDistance:     10,
+		TrackMaxMissedFrames: 9,
+		TrackMaxPath:         270,
+
+		BackgroundLearningRate:  0.01,
+		BackgroundSigma:         4,
+		BackgroundMaxFreezeSecs: 120,
+
+		Calibration: DefaultCalibration(),
+
+		AdaptiveDelta:   false,
+		NoiseMultiplier: 6,
+		DeltaThreshMin:  20,
+		DeltaThreshMax:  100,
+
+		ObjectMinArea:            0,
+		ObjectMaxArea:            0,
+		ObjectMaxAspectRatio:     0,
+		ObjectMinDisplacement:    0,
+		ObjectDisplacementFrames: 18,
+
+		SceneChangeFraction: 0,
+		SceneChangeAction:   SceneChangeSuppress,
+
+		BadPixelDetection: false,
+		BadPixelPeriod:    time.Hour,
+		BadPixelFile:      "/var/lib/thermal-recorder/bad-pixels.json",
+
+		FFCPeriod:       10 * time.Second,
+		FFCCompensation: false,
+		FFCSettle:       time.Second,
 	}
 }
 
 func (conf *MotionConfig) Validate() error {
-	// TODO
-	return nil
+	if _, err := detectorFactory(conf.Detector); err != nil {
+		return err
+	}
+	if conf.TriggerWindow != 0 && conf.TriggerWindow < conf.TriggerFrames {
+		return errors.New("trigger-window must be at least trigger-frames")
+	}
+	if conf.ContinueScore != 0 && conf.StartScore == 0 {
+		return errors.New("continue-score can only be used with start-score")
+	}
+	if conf.ContinueScore > conf.StartScore {
+		return errors.New("continue-score should not be larger than start-score")
+	}
+	if conf.TrackMaxPath < 0 {
+		return errors.New("track-max-path can not be negative")
+	}
+	if conf.BackgroundMaxFreezeSecs < 0 {
+		return errors.New("background-max-freeze-secs can not be negative")
+	}
+	if conf.ObjectMaxArea != 0 && conf.ObjectMaxArea < conf.ObjectMinArea {
+		return errors.New("object-max-area should be larger than object-min-area")
+	}
+	if conf.ObjectMaxAspectRatio != 0 && conf.ObjectMaxAspectRatio < 1 {
+		return errors.New("object-max-aspect-ratio must be at least 1")
+	}
+	if conf.ObjectMinDisplacement != 0 && conf.ObjectDisplacementFrames < 1 {
+		return errors.New("object-displacement-frames must be at least 1")
+	}
+	if conf.BadPixelDetection && conf.BadPixelPeriod < time.Minute {
+		return errors.New("bad-pixel-period must be at least 1m")
+	}
+	if err := validateSceneChange(conf); err != nil {
+		return err
+	}
+	if conf.TempThreshC != nil || conf.DeltaThreshC != nil {
+		if err := conf.Calibration.Validate(); err != nil {
+			return err
+		}
+	}
+	if conf.AdaptiveDelta {
+		if conf.DeltaThreshC != nil {
+			return errors.New("adaptive-delta can't be used with delta-thresh-c")
+		}
+		if conf.DeltaThreshMin > conf.DeltaThreshMax {
+			return errors.New("delta-thresh-max should be larger than delta-thresh-min")
+		}
+	}
+	for i := range conf.Masks {
+		if err := conf.Masks[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return validateZones(conf.Zones)
 }
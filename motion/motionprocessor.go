@@ -19,9 +19,9 @@ package motion
 import (
 	"errors"
 	"log"
+	"time"
 
 	"github.com/TheCacophonyProject/lepton3"
-	"github.com/TheCacophonyProject/window"
 
 	"github.com/TheCacophonyProject/thermal-recorder/recorder"
 )
@@ -29,44 +29,87 @@ import (
 func NewMotionProcessor(motionConf *MotionConfig,
 	recorderConf *recorder.RecorderConfig,
 	listener RecordingListener,
-	recorder recorder.Recorder) *MotionProcessor {
+	rec recorder.Recorder) (*MotionProcessor, error) {
+
+	detector, err := NewDetector(*motionConf)
+	if err != nil {
+		return nil, err
+	}
+
+	previewFrames := recorderConf.PreviewSecs*lepton3.FramesHz + max(motionConf.TriggerFrames, motionConf.TriggerWindow)
+	mergeGapFrames := recorderConf.MergeGapSecs * lepton3.FramesHz
 
 	return &MotionProcessor{
 		minFrames:      recorderConf.MinSecs * lepton3.FramesHz,
 		maxFrames:      recorderConf.MaxSecs * lepton3.FramesHz,
-		motionDetector: NewMotionDetector(*motionConf),
-		frameLoop:      NewFrameLoop(recorderConf.PreviewSecs*lepton3.FramesHz + motionConf.TriggerFrames),
-		isRecording:    false,
-		window:         *window.New(recorderConf.WindowStart.Time, recorderConf.WindowEnd.Time),
-		listener:       listener,
-		conf:           recorderConf,
-		triggerFrames:  motionConf.TriggerFrames,
-		recorder:       recorder,
-	}
+		previewFrames:  previewFrames,
+		mergeGapFrames: mergeGapFrames,
+		splitLong:      recorderConf.SplitLongRecordings,
+		detector:       detector,
+		// The frames during a merge gap are kept so they can be written
+		// if motion resumes.
+		frameLoop:     NewFrameLoop(max(previewFrames, mergeGapFrames+1)),
+		isRecording:   false,
+		schedule:      recorder.NewSchedule(recorderConf),
+		listener:      listener,
+		conf:          recorderConf,
+		triggerFrames: motionConf.TriggerFrames,
+		triggerVotes:  newTriggerVotes(motionConf.TriggerWindow),
+		recorder:      rec,
+		// The object filter looks back over the displacement frames.
+		tracker:       NewTracker(motionConf.TrackMaxDistance, motionConf.TrackMaxMissedFrames, motionConf.TrackMaxPath),
+		objectFilter:  newObjectFilter(motionConf),
+		badPixels:     newBadPixelMap(motionConf),
+		startScore:    motionConf.StartScore,
+		continueScore: motionConf.ContinueScore,
+		frameMetadata: make(frameMetadata),
+	}, nil
 }
 
 type MotionProcessor struct {
 	minFrames      int
 	maxFrames      int
+	previewFrames  int
+	mergeGapFrames int
+	holding        bool
+	heldFrames     int
+	splitLong      bool
+	part           recorder.Part
 	framesWritten  int
-	motionDetector *motionDetector
+	detector       Detector
+	result         Result
 	frameLoop      *FrameLoop
 	isRecording    bool
 	totalFrames    int
 	writeUntil     int
 	lastLogFrame   int
-	window         window.Window
+	schedule       *recorder.Schedule
 	conf           *recorder.RecorderConfig
 	listener       RecordingListener
 	triggerFrames  int
 	triggered      int
+	triggerVotes   *triggerVotes
 	recorder       recorder.Recorder
+	tracker        *Tracker
+	objectFilter   *objectFilter
+	sceneChanged   bool
+	badPixels      *badPixelMap
+	startScore     float64
+	continueScore  float64
+	frameMetadata  frameMetadata
 }
 
+// frameMetadata holds the metadata for the frames in the frame loop, so
+// that it is available when writing preview frames.
+type frameMetadata map[*lepton3.Frame]recorder.FrameMetadata
+
 type RecordingListener interface {
 	MotionDetected()
 	RecordingStarted()
 	RecordingEnded()
+	TrackStarted(*Track)
+	TrackEnded(*Track)
+	SceneChangeSuppressed(changedPixels int)
 }
 
 func (mp *MotionProcessor) Process(rawFrame *lepton3.RawFrame) {
@@ -77,33 +120,87 @@ func (mp *MotionProcessor) Process(rawFrame *lepton3.RawFrame) {
 }
 
 func (mp *MotionProcessor) internalProcess(frame *lepton3.Frame) {
+	received := time.Now()
 	mp.totalFrames++
 
-	if mp.motionDetector.Detect(frame) {
-		if mp.listener != nil {
-			mp.listener.MotionDetected()
-		}
-		mp.triggered++
+	detectFrame := frame
+	if mp.badPixels != nil {
+		mp.badPixels.learn(frame)
+		detectFrame = mp.badPixels.repair(frame)
+	}
+	mp.result = mp.detector.Detect(detectFrame)
+	mp.updateSceneChange()
+	mp.updateTracks()
+	if mp.objectFilter != nil {
+		mp.objectFilter.follow(mp.totalFrames, mp.tracker.Active())
+	}
+	if follower, ok := mp.detector.(TrackFollower); ok {
+		follower.FollowTracks(mp.tracker.Active())
+	}
+	rejected := false
+	if mp.objectFilter != nil && (mp.result.Motion || mp.isRecording && mp.hasContinueScore()) {
+		plausible := mp.objectFilter.anyPlausible(mp.totalFrames, mp.tracker.Active())
+		mp.result.Motion = mp.result.Motion && plausible
+		rejected = !plausible
+	}
+
+	if mp.result.Motion && mp.listener != nil {
+		mp.listener.MotionDetected()
+	}
+
+	metadata := recorder.FrameMetadata{
+		Time:          received,
+		Score:         mp.result.Score,
+		ChangedPixels: mp.result.ChangedPixels,
+		Regions:       metadataRegions(mp.result.Regions),
+	}
+	switch {
+	case mp.result.SceneChange:
+		metadata.Trigger = recorder.TriggerSceneChange
+	case rejected:
+		metadata.Trigger = recorder.TriggerRejected
+	case mp.result.Motion:
+		metadata.Trigger = recorder.TriggerMotion
+	}
 
-		if mp.isRecording {
+	startMotion := mp.isStartMotion()
+	mp.updateTriggered(startMotion)
+	if mp.holding && startMotion && mp.triggered >= mp.triggerFrames {
+		if err := mp.resumeRecording(); err != nil {
+			log.Printf("Failed to resume recording %v", err)
+		}
+		metadata.Trigger = recorder.TriggerResume
+	}
+	if mp.holding {
+		// Nothing is written while waiting to see if motion resumes.
+	} else if mp.isRecording {
+		if mp.isContinueMotion(rejected) {
 			// increase the length of recording
-			mp.writeUntil = min(mp.framesWritten+mp.minFrames, mp.maxFrames)
-		} else if mp.triggered < mp.triggerFrames {
-			// Only start recording after n (triggerFrames) consecutive frames with motion detected.
+			mp.writeUntil = mp.framesWritten + mp.minFrames
+			if !mp.splitLong {
+				mp.writeUntil = min(mp.writeUntil, mp.maxFrames)
+			}
+			if metadata.Trigger == "" {
+				metadata.Trigger = recorder.TriggerContinue
+			}
+		}
+	} else if startMotion {
+		if mp.triggered < mp.triggerFrames {
+			// Only start recording after n (triggerFrames) frames with motion detected.
 		} else if err := mp.canStartWriting(); err != nil {
 			mp.occasionallyWriteError("Recording not started", err)
 		} else if err := mp.startRecording(); err != nil {
 			mp.occasionallyWriteError("Can't start recording file", err)
 		} else {
 			mp.writeUntil = mp.minFrames
+			metadata.Trigger = recorder.TriggerStart
 		}
-	} else {
-		mp.triggered = 0
 	}
+	mp.frameMetadata[frame] = metadata
 
 	// If recording, write the frame.
-	if mp.isRecording {
-		err := mp.recorder.WriteFrame(frame)
+	if mp.isRecording && !mp.holding {
+		err := mp.writeFrame(frame)
 		if err != nil {
 			log.Printf("Failed to write to CPTV file %v", err)
 		}
@@ -112,11 +209,61 @@ func (mp *MotionProcessor) internalProcess(frame *lepton3.Frame) {
 
 	mp.frameLoop.Move()
 
-	if mp.isRecording && mp.framesWritten >= mp.writeUntil {
-		err := mp.stopRecording()
-		if err != nil {
-			log.Printf("Failed to stop recording CPTV file %v", err)
+	if mp.holding {
+		mp.heldFrames++
+		if mp.heldFrames >= mp.mergeGapFrames {
+			mp.stop()
+		}
+	} else if mp.isRecording && mp.framesWritten >= mp.writeUntil {
+		if mp.mergeGapFrames > 0 && mp.framesWritten+mp.mergeGapFrames < mp.maxFrames {
+			mp.hold()
+		} else {
+			mp.stop()
 		}
+	} else if mp.isRecording && mp.framesWritten >= mp.maxFrames {
+		// Only reached when long recordings are split.
+		mp.startNextPart()
+	}
+}
+
+// startNextPart carries a recording which has reached its maximum length
+// on into a new file, so that no frames are lost between the parts.
+func (mp *MotionProcessor) startNextPart() {
+	if err := mp.recorder.StopRecording(); err != nil {
+		log.Printf("Failed to stop recording part %d %v", mp.part.Number, err)
+	}
+	mp.part = mp.part.Next()
+	mp.writeUntil -= mp.framesWritten
+	mp.framesWritten = 0
+	if err := recorder.StartRecordingPart(mp.recorder, mp.part); err != nil {
+		log.Printf("Failed to start recording part %d %v", mp.part.Number, err)
+		mp.stop()
+	}
+}
+
+// hold stops writing frames but keeps the recording open for the merge
+// gap, so that if motion resumes the recording can be continued instead
+// of starting a new one.  The frames from now on are kept in the frame
+// loop in case they are needed.
+func (mp *MotionProcessor) hold() {
+	mp.holding = true
+	mp.heldFrames = 0
+	mp.frameLoop.SetAsOldest()
+}
+
+// resumeRecording writes the frames held during the merge gap so the
+// recording carries on as if it hadn't paused.
+func (mp *MotionProcessor) resumeRecording() error {
+	mp.holding = false
+	written, err := mp.writeHistory(len(mp.frameLoop.GetHistory()))
+	mp.framesWritten += written
+	return err
+}
+
+func (mp *MotionProcessor) stop() {
+	err := mp.stopRecording()
+	if err != nil {
+		log.Printf("Failed to stop recording CPTV file %v", err)
 	}
 }
 
@@ -128,13 +275,143 @@ func (mp *MotionProcessor) ProcessFrame(srcFrame *lepton3.Frame) {
 	mp.internalProcess(frame)
 }
 
+// isStartMotion returns true if the last frame had enough motion to count
+// towards starting a recording.
+func (mp *MotionProcessor) isStartMotion() bool {
+	return mp.result.Motion && mp.result.Score >= mp.startScore
+}
+
+// isContinueMotion returns true if the last frame had enough motion to
+// extend a recording.  Less evidence can be required than for starting a
+// recording so that animals which are partly hidden are still recorded:
+// with a continue score a frame counts even if too few pixels changed for
+// motion to be detected, as long as what moved wasn't rejected.
+func (mp *MotionProcessor) isContinueMotion(rejected bool) bool {
+	if mp.continueScore == 0 {
+		return mp.result.Motion
+	}
+	return mp.hasContinueScore() && !rejected && !mp.result.SceneChange
+}
+
+// hasContinueScore returns true if the last frame scored enough to
+// extend a recording.
+func (mp *MotionProcessor) hasContinueScore() bool {
+	return mp.continueScore > 0 && mp.result.Score >= mp.continueScore
+}
+
+func metadataRegions(regions []Region) []recorder.Region {
+	out := make([]recorder.Region, len(regions))
+	for i, r := range regions {
+		out[i] = recorder.Region(r)
+	}
+	return out
+}
+
+func (mp *MotionProcessor) writeFrame(frame *lepton3.Frame) error {
+	return recorder.WriteFrame(mp.recorder, frame, mp.frameMetadata[frame])
+}
+
+// updateTriggered counts the frames with motion which count towards
+// starting a recording.  Without a trigger window only consecutive frames
+// count.
+func (mp *MotionProcessor) updateTriggered(motion bool) {
+	if mp.triggerVotes != nil {
+		mp.triggered = mp.triggerVotes.add(motion)
+	} else if motion {
+		mp.triggered++
+	} else {
+		mp.triggered = 0
+	}
+}
+
+// updateSceneChange lets the listener know when motion starts being
+// suppressed because of a change to the whole scene.  Consecutive
+// suppressed frames are only reported once.
+func (mp *MotionProcessor) updateSceneChange() {
+	if mp.result.SceneChange && !mp.sceneChanged && mp.listener != nil {
+		mp.listener.SceneChangeSuppressed(mp.result.ChangedPixels)
+	}
+	mp.sceneChanged = mp.result.SceneChange
+}
+
+func (mp *MotionProcessor) updateTracks() {
+	started, ended := mp.tracker.Update(mp.totalFrames, mp.result.Regions)
+	if mp.listener == nil {
+		return
+	}
+	for _, track := range ended {
+		mp.listener.TrackEnded(track)
+	}
+	for _, track := range started {
+		mp.listener.TrackStarted(track)
+	}
+}
+
+// Tracks returns the objects currently being tracked.
+// Note: The returned tracks will continue to be updated as frames are processed.
+func (mp *MotionProcessor) Tracks() []*Track {
+	return mp.tracker.Active()
+}
+
+// Regions returns the groups of changed pixels found in the most
+// recently processed frame.
+func (mp *MotionProcessor) Regions() []Region {
+	return mp.result.Regions
+}
+
+// LastResult returns what the detector found in the most recently
+// processed frame.
+func (mp *MotionProcessor) LastResult() Result {
+	return mp.result
+}
+
+// Rejections returns how many objects have been ignored because they
+// didn't look like animals.
+func (mp *MotionProcessor) Rejections() Rejections {
+	if mp.objectFilter == nil {
+		return Rejections{}
+	}
+	return mp.objectFilter.rejections
+}
+
+// BadPixels returns the stuck and flickering pixels which are being
+// ignored by motion detection.
+func (mp *MotionProcessor) BadPixels() []BadPixel {
+	if mp.badPixels == nil {
+		return nil
+	}
+	return mp.badPixels.Pixels()
+}
+
+// IsRecording returns true while a recording is being made, including
+// while it is held open waiting to see if motion resumes.
+func (mp *MotionProcessor) IsRecording() bool {
+	return mp.isRecording
+}
+
+// HeldFrames returns how many frames have passed since a recording was
+// held open waiting to see if motion resumes.  It is 0 when the recording
+// isn't being held.
+func (mp *MotionProcessor) HeldFrames() int {
+	if !mp.holding {
+		return 0
+	}
+	return mp.heldFrames
+}
+
+// Schedule returns the schedule which decides when recordings can be
+// made.
+func (mp *MotionProcessor) Schedule() *recorder.Schedule {
+	return mp.schedule
+}
+
 func (mp *MotionProcessor) GetRecentFrame(frame *lepton3.Frame) *lepton3.Frame {
 	return mp.frameLoop.CopyRecent(frame)
 }
 
 func (mp *MotionProcessor) canStartWriting() error {
-	if !mp.window.Active() {
-		return errors.New("motion detected but outside of recording window")
+	if !mp.schedule.Active() {
+		return errors.New("motion detected but outside of recording schedule")
 	} else {
 		return mp.recorder.CheckCanRecord()
 	}
@@ -152,7 +429,13 @@ func (mp *MotionProcessor) startRecording() error {
 
 	var err error
 
-	if err = mp.recorder.StartRecording(); err != nil {
+	mp.part = recorder.Part{}
+	if mp.splitLong {
+		if mp.part, err = recorder.NewPart(); err != nil {
+			return err
+		}
+	}
+	if err = recorder.StartRecordingPart(mp.recorder, mp.part); err != nil {
 		return err
 	}
 
@@ -176,8 +459,17 @@ func (mp *MotionProcessor) stopRecording() error {
 	mp.writeUntil = 0
 	mp.isRecording = false
 	mp.triggered = 0
-	// if it starts recording again very quickly it won't write the same frames again
-	mp.frameLoop.SetAsOldest()
+	if mp.triggerVotes != nil {
+		mp.triggerVotes.reset()
+	}
+	if mp.holding {
+		// The frames since the recording was held haven't been written
+		// so they can be used as preview frames.
+		mp.holding = false
+	} else {
+		// if it starts recording again very quickly it won't write the same frames again
+		mp.frameLoop.SetAsOldest()
+	}
 
 	return err
 }
@@ -190,18 +482,28 @@ func min(a, b int) int {
 }
 
 func (mp *MotionProcessor) recordPreTriggerFrames() error {
+	_, err := mp.writeHistory(mp.previewFrames)
+	return err
+}
+
+// writeHistory writes up to the last count frames in the frame loop and
+// returns how many were written.
+func (mp *MotionProcessor) writeHistory(count int) (int, error) {
 	frames := mp.frameLoop.GetHistory()
+	if len(frames) > count {
+		frames = frames[len(frames)-count:]
+	}
 	var frame *lepton3.Frame
 	ii := 0
 
 	// it never writes the current frame as this will be written later
 	for ii < len(frames)-1 {
 		frame = frames[ii]
-		if err := mp.recorder.WriteFrame(frame); err != nil {
-			return err
+		if err := mp.writeFrame(frame); err != nil {
+			return ii, err
 		}
 		ii++
 	}
 
-	return nil
+	return ii, nil
 }
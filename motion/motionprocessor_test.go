@@ -19,9 +19,11 @@ package motion
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/TheCacophonyProject/lepton3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/TheCacophonyProject/thermal-recorder/recorder"
 )
@@ -30,11 +32,15 @@ type TestRecorder struct {
 	frameIds         []int
 	index            int
 	previousFrameIds []int
+	metadata         []recorder.FrameMetadata
+	parts            []recorder.Part
+	recordings       [][]int
 	CanRecordReturn  error
 }
 
 func (tr *TestRecorder) StopRecording() error {
 	tr.previousFrameIds = tr.frameIds[:tr.index]
+	tr.recordings = append(tr.recordings, tr.previousFrameIds)
 	tr.frameIds = nil
 	return nil
 }
@@ -44,15 +50,26 @@ func (tr *TestRecorder) CheckCanRecord() error { return tr.CanRecordReturn }
 func (tr *TestRecorder) StartRecording() error {
 	tr.frameIds = make([]int, 200)
 	tr.index = 0
+	tr.metadata = nil
 	return nil
 }
 
+func (tr *TestRecorder) StartRecordingPart(part recorder.Part) error {
+	tr.parts = append(tr.parts, part)
+	return tr.StartRecording()
+}
+
 func (tr *TestRecorder) WriteFrame(frame *lepton3.Frame) error {
 	tr.frameIds[tr.index] = int(frame.Pix[0][0])
 	tr.index++
 	return nil
 }
 
+func (tr *TestRecorder) WriteFrameWithMetadata(frame *lepton3.Frame, metadata recorder.FrameMetadata) error {
+	tr.metadata = append(tr.metadata, metadata)
+	return tr.WriteFrame(frame)
+}
+
 func (tr *TestRecorder) GetRecordedFramesIds() []int {
 	return tr.previousFrameIds
 }
@@ -84,6 +101,7 @@ func MotionTestConfig() *MotionConfig {
 	config.TriggerFrames = 1
 	config.UseOneDiffOnly = true
 	config.WarmerOnly = true
+	config.FFCPeriod = 10 * time.Second
 	return config
 }
 
@@ -97,12 +115,23 @@ func FramesFrom(start, end int) []int {
 
 func SetupTest(mConf *MotionConfig, rConf *recorder.RecorderConfig) (*TestRecorder, *TestFrameMaker) {
 	recorder := new(TestRecorder)
-	processor := NewMotionProcessor(mConf, rConf, nil, recorder)
+	processor, err := NewMotionProcessor(mConf, rConf, nil, recorder)
+	if err != nil {
+		panic(err)
+	}
 
 	scenarioMaker := MakeTestFrameMaker(processor)
 	return recorder, scenarioMaker
 }
 
+func TestUnknownDetectorIsAnError(t *testing.T) {
+	config := MotionTestConfig()
+	config.Detector = "magic"
+	processor, err := NewMotionProcessor(config, RecorderTestConfig(), nil, new(TestRecorder))
+	assert.Nil(t, processor)
+	assert.Error(t, err)
+}
+
 func TestRecorderNotTriggeredUnlessSeesMovement(t *testing.T) {
 	recorder, scenarioMaker := SetupTest(MotionTestConfig(), RecorderTestConfig())
 	scenarioMaker.AddBackgroundFrames(20)
@@ -130,6 +159,144 @@ func TestRecorderNotTriggeredUntilTriggerFramesReached(t *testing.T) {
 	assert.Equal(t, FramesFrom(11, 48), recorder.GetRecordedFramesIds())
 }
 
+func TestRecorderTriggeredByEnoughFramesInWindow(t *testing.T) {
+	config := MotionTestConfig()
+	config.TriggerFrames = 3
+	config.TriggerWindow = 5
+
+	recorder, scenarioMaker := SetupTest(config, RecorderTestConfig())
+
+	// not triggered by 3 moving frames spread over more than 5 frames
+	scenarioMaker.AddBackgroundFrames(10).AddMovingDotFrames(1).AddBackgroundFrames(2)
+	scenarioMaker.AddMovingDotFrames(1).AddBackgroundFrames(2).AddMovingDotFrames(1)
+	scenarioMaker.AddBackgroundFrames(8)
+	assert.False(t, recorder.IsRecording())
+
+	// triggered by 3 moving frames within 5 frames, even though they
+	// aren't consecutive
+	scenarioMaker.AddMovingDotFrames(1).AddBackgroundFrames(1).AddMovingDotFrames(1)
+	scenarioMaker.AddBackgroundFrames(1).AddMovingDotFrames(1)
+	assert.True(t, recorder.IsRecording())
+}
+
+func TestRecorderNotTriggeredBySmallObjects(t *testing.T) {
+	config := MotionTestConfig()
+	config.ObjectMinArea = 10
+
+	recorder, scenarioMaker := SetupTest(config, RecorderTestConfig())
+
+	// the moving dot is only 9 pixels
+	scenarioMaker.AddBackgroundFrames(10).AddMovingDotFrames(3).AddBackgroundFrames(5)
+	assert.False(t, recorder.IsRecording())
+	assert.Equal(t, 3, scenarioMaker.processor.Rejections().TooSmall)
+}
+
+func TestRecordingContinuesWithLessEvidenceThanToStart(t *testing.T) {
+	config := MotionTestConfig()
+	config.CountThresh = 5
+	config.StartScore = 20
+	config.ContinueScore = 5
+
+	recorder, scenarioMaker := SetupTest(config, RecorderTestConfig())
+
+	// a partly hidden animal isn't enough to start a recording
+	scenarioMaker.AddBackgroundFrames(10).AddMovingDotFrames(3).AddBackgroundFrames(10)
+	assert.False(t, recorder.IsRecording())
+
+	// but is enough to keep one going
+	scenarioMaker.BrightSpotSize = 5
+	scenarioMaker.AddMovingDotFrames(1)
+	scenarioMaker.BrightSpotSize = 3
+	scenarioMaker.AddMovingDotFrames(30)
+	assert.True(t, recorder.IsRecording())
+}
+
+func TestRecordingStopsWithoutContinueScore(t *testing.T) {
+	config := MotionTestConfig()
+	config.CountThresh = 20
+	config.StartScore = 20
+
+	recorder, scenarioMaker := SetupTest(config, RecorderTestConfig())
+
+	scenarioMaker.AddBackgroundFrames(10)
+	scenarioMaker.BrightSpotSize = 5
+	scenarioMaker.AddMovingDotFrames(1)
+	scenarioMaker.BrightSpotSize = 3
+	scenarioMaker.AddMovingDotFrames(30)
+	assert.False(t, recorder.IsRecording())
+}
+
+func TestRecordingContinuesWithoutMotionAboveContinueScore(t *testing.T) {
+	config := MotionTestConfig()
+	config.CountThresh = 20
+	config.StartScore = 20
+	config.ContinueScore = 5
+
+	recorder, scenarioMaker := SetupTest(config, RecorderTestConfig())
+
+	scenarioMaker.AddBackgroundFrames(10)
+	scenarioMaker.BrightSpotSize = 5
+	scenarioMaker.AddMovingDotFrames(1)
+	assert.True(t, recorder.IsRecording())
+
+	// the smaller dot is too small for motion to be detected but scores
+	// enough to continue
+	scenarioMaker.BrightSpotSize = 3
+	scenarioMaker.AddMovingDotFrames(30)
+	assert.True(t, recorder.IsRecording())
+}
+
+func TestRejectedObjectsDontContinueRecording(t *testing.T) {
+	config := MotionTestConfig()
+	config.CountThresh = 5
+	config.StartScore = 20
+	config.ContinueScore = 5
+	config.ObjectMinArea = 10
+
+	recorder, scenarioMaker := SetupTest(config, RecorderTestConfig())
+
+	scenarioMaker.AddBackgroundFrames(10)
+	scenarioMaker.BrightSpotSize = 5
+	scenarioMaker.AddMovingDotFrames(1)
+	assert.True(t, recorder.IsRecording())
+
+	// the smaller dot scores enough to continue but is too small to be an
+	// animal
+	scenarioMaker.BrightSpotSize = 3
+	scenarioMaker.AddMovingDotFrames(30)
+	assert.False(t, recorder.IsRecording())
+}
+
+func TestScoresWrittenWithFrames(t *testing.T) {
+	recorder, scenarioMaker := SetupTest(MotionTestConfig(), RecorderTestConfig())
+
+	scenarioMaker.AddBackgroundFrames(11).AddMovingDotFrames(1)
+	var scores []float64
+	for _, m := range recorder.metadata {
+		scores = append(scores, m.Score)
+	}
+	assert.Equal(t, []float64{0, 0, 0, 0, 0, 0, 0, 0, 0, 9}, scores)
+}
+
+func TestFrameMetadataWrittenWithFrames(t *testing.T) {
+	config := MotionTestConfig()
+	config.TriggerFrames = 2
+	recorder, scenarioMaker := SetupTest(config, RecorderTestConfig())
+
+	scenarioMaker.AddBackgroundFrames(11).AddMovingDotFrames(2)
+	metadata := recorder.metadata
+	require.Len(t, metadata, 11)
+
+	assert.Equal(t, "", metadata[8].Trigger)
+	assert.Equal(t, "motion", metadata[9].Trigger)
+	assert.Equal(t, "start", metadata[10].Trigger)
+	assert.Equal(t, 9, metadata[10].ChangedPixels)
+	require.Len(t, metadata[10].Regions, 1)
+	assert.Equal(t, 9, metadata[10].Regions[0].Area)
+	assert.False(t, metadata[0].Time.IsZero())
+	assert.False(t, metadata[10].Time.Before(metadata[0].Time))
+}
+
 func TestRecorderNotStartedIfCheckCanRecordReturnsError(t *testing.T) {
 	recorder, scenarioMaker := SetupTest(MotionTestConfig(), RecorderTestConfig())
 	recorder.SetCheckError(errors.New("Cannot record or bad things will happen"))
@@ -160,3 +327,76 @@ func TestMultipleRecordingsDontRepeatAnyFrames(t *testing.T) {
 	scenarioMaker.AddMovingDotFrames(1).AddBackgroundFrames(39)
 	assert.Equal(t, FramesFrom(38, 67), recorder.GetRecordedFramesIds())
 }
+
+func TestRecordingsWithShortGapAreMerged(t *testing.T) {
+	rConfig := RecorderTestConfig()
+	rConfig.MergeGapSecs = 2
+	recorder, scenarioMaker := SetupTest(MotionTestConfig(), rConfig)
+
+	scenarioMaker.AddBackgroundFrames(11).AddMovingDotFrames(1).AddBackgroundFrames(30)
+	assert.True(t, recorder.IsRecording())
+
+	scenarioMaker.AddMovingDotFrames(1).AddBackgroundFrames(60)
+	assert.False(t, recorder.IsRecording())
+	assert.Equal(t, FramesFrom(2, 68), recorder.GetRecordedFramesIds())
+	assert.Equal(t, "resume", recorder.metadata[40].Trigger)
+}
+
+func TestRecordingsWithLongGapAreNotMerged(t *testing.T) {
+	rConfig := RecorderTestConfig()
+	rConfig.MergeGapSecs = 1
+	recorder, scenarioMaker := SetupTest(MotionTestConfig(), rConfig)
+
+	scenarioMaker.AddBackgroundFrames(11).AddMovingDotFrames(1).AddBackgroundFrames(39)
+	assert.False(t, recorder.IsRecording())
+	assert.Equal(t, FramesFrom(2, 37), recorder.GetRecordedFramesIds())
+
+	scenarioMaker.AddMovingDotFrames(1).AddBackgroundFrames(60)
+	assert.Equal(t, FramesFrom(42, 77), recorder.GetRecordedFramesIds())
+}
+
+func TestHeldFramesCountedDuringMergeGap(t *testing.T) {
+	rConfig := RecorderTestConfig()
+	rConfig.MergeGapSecs = 1
+	recorder, scenarioMaker := SetupTest(MotionTestConfig(), rConfig)
+
+	scenarioMaker.AddBackgroundFrames(11).AddMovingDotFrames(1).AddBackgroundFrames(30)
+	assert.True(t, recorder.IsRecording())
+	held := scenarioMaker.processor.HeldFrames()
+	assert.True(t, held > 0 && held < 9, "held %d frames", held)
+
+	scenarioMaker.AddBackgroundFrames(9)
+	assert.False(t, recorder.IsRecording())
+	assert.Equal(t, 0, scenarioMaker.processor.HeldFrames())
+}
+
+func TestLongRecordingsSplitIntoParts(t *testing.T) {
+	rConfig := RecorderTestConfig()
+	rConfig.MinSecs = 1
+	rConfig.MaxSecs = 1
+	rConfig.SplitLongRecordings = true
+	recorder, scenarioMaker := SetupTest(MotionTestConfig(), rConfig)
+
+	scenarioMaker.AddBackgroundFrames(11).AddMovingDotFrames(25).AddBackgroundFrames(30)
+	assert.False(t, recorder.IsRecording())
+	require.True(t, len(recorder.parts) > 1)
+
+	var frames []int
+	for i, part := range recorder.parts {
+		assert.Equal(t, i+1, part.Number)
+		assert.Equal(t, recorder.parts[0].SessionID, part.SessionID)
+		frames = append(frames, recorder.recordings[i]...)
+	}
+	assert.Equal(t, FramesFrom(2, frames[len(frames)-1]), frames)
+}
+
+func TestLongRecordingsNotSplitByDefault(t *testing.T) {
+	rConfig := RecorderTestConfig()
+	rConfig.MinSecs = 1
+	rConfig.MaxSecs = 1
+	recorder, scenarioMaker := SetupTest(MotionTestConfig(), rConfig)
+
+	scenarioMaker.AddBackgroundFrames(11).AddMovingDotFrames(25)
+	assert.Empty(t, recorder.parts[0].SessionID)
+	assert.Equal(t, FramesFrom(2, 19), recorder.recordings[0])
+}
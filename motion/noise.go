@@ -0,0 +1,122 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"log"
+
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+const (
+	// Differences at or above this are treated as movement rather than noise.
+	noiseHistogramSize = 256
+
+	// Too few usable pixels (eg a cold scene below temp-thresh) doesn't give
+	// a useful estimate.
+	minNoiseSamples = 100
+
+	// How quickly the noise estimate follows changes in the measured noise.
+	noiseSmoothing = 0.05
+
+	// Scales the median absolute deviation to a standard deviation.
+	madToStdDev = 1.4826
+)
+
+func newNoiseEstimator(conf MotionConfig) *noiseEstimator {
+	if !conf.AdaptiveDelta {
+		return nil
+	}
+	return &noiseEstimator{
+		multiplier: conf.NoiseMultiplier,
+		minThresh:  conf.DeltaThreshMin,
+		maxThresh:  conf.DeltaThreshMax,
+		verbose:    conf.Verbose,
+		thresh:     conf.DeltaThresh,
+	}
+}
+
+// noiseEstimator tracks the sensor noise using the median absolute
+// difference between frames over pixels which aren't moving, and derives
+// a delta threshold from it.
+type noiseEstimator struct {
+	multiplier float64
+	minThresh  uint16
+	maxThresh  uint16
+	verbose    bool
+	noise      float64
+	thresh     uint16
+	histogram  [noiseHistogramSize]int
+}
+
+// update adds the differences between a and b to the noise estimate and
+// returns the delta threshold to use.  Pixels which are below tempThresh,
+// masked or marked as moving are ignored.
+func (ne *noiseEstimator) update(a, b *lepton3.Frame, tempThresh uint16, skip *pixelMask, start, rowStop, colStop int) uint16 {
+	for i := range ne.histogram {
+		ne.histogram[i] = 0
+	}
+	samples := 0
+	for y := start; y < rowStop; y++ {
+		for x := start; x < colStop; x++ {
+			if skip[y][x] || a.Pix[y][x] <= tempThresh || b.Pix[y][x] <= tempThresh {
+				continue
+			}
+			diff := absDiff(a.Pix[y][x], b.Pix[y][x])
+			if diff < noiseHistogramSize {
+				ne.histogram[diff]++
+			}
+			samples++
+		}
+	}
+	if samples < minNoiseSamples {
+		return ne.thresh
+	}
+
+	median := 0
+	for seen := 0; median < noiseHistogramSize; median++ {
+		seen += ne.histogram[median]
+		if seen*2 >= samples {
+			break
+		}
+	}
+	measured := madToStdDev * float64(median)
+
+	if ne.noise == 0 {
+		ne.noise = measured
+	} else {
+		ne.noise += noiseSmoothing * (measured - ne.noise)
+	}
+
+	thresh := ne.current()
+	if thresh != ne.thresh && ne.verbose {
+		log.Printf("noise %.1f, delta-thresh %d", ne.noise, thresh)
+	}
+	ne.thresh = thresh
+	return thresh
+}
+
+func (ne *noiseEstimator) current() uint16 {
+	thresh := toUint16(ne.multiplier * ne.noise)
+	if thresh < ne.minThresh {
+		return ne.minThresh
+	}
+	if thresh > ne.maxThresh {
+		return ne.maxThresh
+	}
+	return thresh
+}
@@ -0,0 +1,78 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+)
+
+func adaptiveTestConfig() MotionConfig {
+	config := defaultMotionParams()
+	config.UseOneDiffOnly = true
+	config.AdaptiveDelta = true
+	config.NoiseMultiplier = 5
+	config.DeltaThreshMin = 10
+	config.DeltaThreshMax = 200
+	return config
+}
+
+// noisyFrames runs frames with normally distributed noise through the
+// detector and returns the delta threshold used for the last frame.
+func noisyFrames(detector Detector, stdDev float64, frames int) uint16 {
+	gen := newFrameGen(nil)
+	random := rand.New(rand.NewSource(1))
+	var result Result
+	for i := 0; i < frames; i++ {
+		frame := gen.setupFrame(3300)
+		for y := 0; y < lepton3.FrameRows; y++ {
+			for x := 0; x < lepton3.FrameCols; x++ {
+				frame.Pix[y][x] = uint16(3300 + random.NormFloat64()*stdDev)
+			}
+		}
+		result = detector.Detect(frame)
+	}
+	return result.DeltaThresh
+}
+
+func TestAdaptiveDeltaFollowsNoise(t *testing.T) {
+	quiet := noisyFrames(NewMotionDetector(adaptiveTestConfig()), 4, 100)
+	noisy := noisyFrames(NewMotionDetector(adaptiveTestConfig()), 12, 100)
+
+	// The frame difference has a standard deviation of sqrt(2) times the
+	// pixel noise.
+	assert.InDelta(t, 5*4*1.41, float64(quiet), 5)
+	assert.InDelta(t, 5*12*1.41, float64(noisy), 10)
+}
+
+func TestAdaptiveDeltaIsBounded(t *testing.T) {
+	config := adaptiveTestConfig()
+	config.DeltaThreshMin = 40
+	config.DeltaThreshMax = 60
+
+	assert.Equal(t, uint16(40), noisyFrames(NewMotionDetector(config), 1, 50))
+	assert.Equal(t, uint16(60), noisyFrames(NewMotionDetector(config), 20, 50))
+}
+
+func TestFixedDeltaWithoutAdaptive(t *testing.T) {
+	config := adaptiveTestConfig()
+	config.AdaptiveDelta = false
+	assert.Equal(t, config.DeltaThresh, noisyFrames(NewMotionDetector(config), 12, 20))
+}
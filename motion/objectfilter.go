@@ -0,0 +1,162 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"log"
+	"math"
+)
+
+// Rejections counts the objects which weren't considered plausible
+// animals, by reason.  Each object is counted once, against the first
+// check it failed.
+type Rejections struct {
+	TooSmall     int
+	TooLarge     int
+	TooElongated int
+	TooSlow      int
+}
+
+// objectFilter decides whether the objects being tracked look like
+// animals rather than insects close to the lens or vegetation moving in
+// the wind.  Limits which are 0 aren't checked.
+type objectFilter struct {
+	minArea            int
+	maxArea            int
+	maxAspectRatio     float64
+	minDisplacement    float64
+	displacementFrames int
+	verbose            bool
+	rejections         Rejections
+
+	// recent holds the positions of each track over the last
+	// displacementFrames frames, keyed by track ID.  The filter keeps its
+	// own as the tracker may keep fewer.
+	recent map[int][]TrackPoint
+}
+
+// newObjectFilter returns nil if none of the object filters are
+// configured.
+func newObjectFilter(conf *MotionConfig) *objectFilter {
+	if conf.ObjectMinArea == 0 && conf.ObjectMaxArea == 0 &&
+		conf.ObjectMaxAspectRatio == 0 && conf.ObjectMinDisplacement == 0 {
+		return nil
+	}
+	return &objectFilter{
+		minArea:            conf.ObjectMinArea,
+		maxArea:            conf.ObjectMaxArea,
+		maxAspectRatio:     conf.ObjectMaxAspectRatio,
+		minDisplacement:    conf.ObjectMinDisplacement,
+		displacementFrames: conf.ObjectDisplacementFrames,
+		verbose:            conf.Verbose,
+		recent:             make(map[int][]TrackPoint),
+	}
+}
+
+// follow records where the tracks seen in frame are so that how far they
+// move can be checked.  It should be called for every frame.
+func (f *objectFilter) follow(frame int, tracks []*Track) {
+	if f.minDisplacement == 0 {
+		return
+	}
+	for _, track := range tracks {
+		if track.EndFrame != frame {
+			continue
+		}
+		points := f.recent[track.ID]
+		if len(points) > 0 && points[len(points)-1].Frame == frame {
+			continue
+		}
+		points = append(points, track.Last())
+		for len(points) > 1 && points[0].Frame < frame-f.displacementFrames {
+			points = points[1:]
+		}
+		f.recent[track.ID] = points
+	}
+	for id, points := range f.recent {
+		if points[len(points)-1].Frame < frame-f.displacementFrames {
+			delete(f.recent, id)
+		}
+	}
+}
+
+// anyPlausible returns true if any of the tracks seen in frame looks like
+// an animal.
+func (f *objectFilter) anyPlausible(frame int, tracks []*Track) bool {
+	f.follow(frame, tracks)
+	found := false
+	for _, track := range tracks {
+		if track.EndFrame == frame && f.plausible(frame, track) {
+			found = true
+		}
+	}
+	return found
+}
+
+// plausible returns true if the track looks like an animal.  A track
+// which hasn't been followed for long enough to see how far it moves
+// isn't plausible yet but isn't counted as rejected either.
+func (f *objectFilter) plausible(frame int, track *Track) bool {
+	r := &track.Region
+	switch {
+	case f.minArea > 0 && r.Area < f.minArea:
+		f.reject(track, &f.rejections.TooSmall, "too small")
+	case f.maxArea > 0 && r.Area > f.maxArea:
+		f.reject(track, &f.rejections.TooLarge, "too large")
+	case f.maxAspectRatio > 0 && aspectRatio(r) > f.maxAspectRatio:
+		f.reject(track, &f.rejections.TooElongated, "too elongated")
+	case f.minDisplacement > 0 && frame-track.StartFrame < f.displacementFrames:
+		// Too soon to tell.
+	case f.minDisplacement > 0 && f.displacement(track, frame-f.displacementFrames) < f.minDisplacement:
+		f.reject(track, &f.rejections.TooSlow, "too slow")
+	default:
+		return true
+	}
+	return false
+}
+
+// reject counts a track as rejected the first time it fails a check.
+func (f *objectFilter) reject(track *Track, count *int, reason string) {
+	if track.rejected {
+		return
+	}
+	track.rejected = true
+	*count++
+	if f.verbose {
+		log.Printf("Track %d rejected: %s (area %d, %dx%d)", track.ID, reason,
+			track.Region.Area, track.Region.Width(), track.Region.Height())
+	}
+}
+
+// aspectRatio returns the ratio of the longest side of the region's
+// bounding box to the shortest, so it is always at least 1.
+func aspectRatio(r *Region) float64 {
+	w, h := float64(r.Width()), float64(r.Height())
+	return math.Max(w, h) / math.Min(w, h)
+}
+
+// displacement returns how far the track has moved since the first
+// position recorded at or after sinceFrame.
+func (f *objectFilter) displacement(track *Track, sinceFrame int) float64 {
+	last := track.Last()
+	for _, p := range f.recent[track.ID] {
+		if p.Frame >= sinceFrame {
+			return math.Hypot(last.X-p.X, last.Y-p.Y)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTrack(frame int, left, top, right, bottom int) *Track {
+	r := Region{
+		Left:      left,
+		Top:       top,
+		Right:     right,
+		Bottom:    bottom,
+		CentroidX: float64(left+right) / 2,
+		CentroidY: float64(top+bottom) / 2,
+	}
+	r.Area = r.Width() * r.Height()
+	track := &Track{ID: 1, StartFrame: frame}
+	track.add(frame, &r)
+	return track
+}
+
+func TestObjectFilterDisabledByDefault(t *testing.T) {
+	config := DefaultMotionConfig()
+	assert.Nil(t, newObjectFilter(&config))
+}
+
+func TestObjectFilterArea(t *testing.T) {
+	config := DefaultMotionConfig()
+	config.ObjectMinArea = 4
+	config.ObjectMaxArea = 100
+	f := newObjectFilter(&config)
+
+	assert.False(t, f.anyPlausible(1, []*Track{makeTrack(1, 0, 0, 0, 0)}))
+	assert.False(t, f.anyPlausible(1, []*Track{makeTrack(1, 0, 0, 19, 19)}))
+	assert.True(t, f.anyPlausible(1, []*Track{makeTrack(1, 0, 0, 4, 4)}))
+	assert.Equal(t, Rejections{TooSmall: 1, TooLarge: 1}, f.rejections)
+}
+
+func TestObjectFilterAspectRatio(t *testing.T) {
+	config := DefaultMotionConfig()
+	config.ObjectMaxAspectRatio = 3
+	f := newObjectFilter(&config)
+
+	assert.False(t, f.anyPlausible(1, []*Track{makeTrack(1, 0, 0, 0, 9)}))
+	assert.True(t, f.anyPlausible(1, []*Track{makeTrack(1, 0, 0, 2, 5)}))
+	assert.Equal(t, Rejections{TooElongated: 1}, f.rejections)
+}
+
+func TestObjectFilterDisplacement(t *testing.T) {
+	config := DefaultMotionConfig()
+	config.ObjectMinDisplacement = 5
+	config.ObjectDisplacementFrames = 4
+	f := newObjectFilter(&config)
+
+	// Swaying on the spot.  The track isn't judged until it is old
+	// enough and is only counted once.
+	track := makeTrack(1, 10, 10, 12, 12)
+	for frame := 2; frame <= 8; frame++ {
+		offset := frame % 2
+		r := Region{CentroidX: float64(11 + offset), CentroidY: 11}
+		track.add(frame, &r)
+		assert.False(t, f.anyPlausible(frame, []*Track{track}))
+		if frame == 4 {
+			assert.Equal(t, Rejections{}, f.rejections)
+		}
+	}
+	assert.Equal(t, Rejections{TooSlow: 1}, f.rejections)
+
+	// Moving steadily.
+	for frame := 9; frame <= 12; frame++ {
+		r := Region{CentroidX: float64(11 + 2*(frame-8)), CentroidY: 11}
+		track.add(frame, &r)
+		f.follow(frame, []*Track{track})
+	}
+	assert.True(t, f.anyPlausible(12, []*Track{track}))
+}
+
+func TestObjectFilterDisplacementWithShortTrackPath(t *testing.T) {
+	config := DefaultMotionConfig()
+	config.ObjectMinDisplacement = 5
+	config.ObjectDisplacementFrames = 4
+	f := newObjectFilter(&config)
+	tracker := NewTracker(10, 2, 1)
+
+	// Moving steadily, but too slowly to be seen in the tracker's path.
+	for frame := 1; frame <= 8; frame++ {
+		tracker.Update(frame, []Region{regionAt(float64(10+2*frame), 10)})
+		f.follow(frame, tracker.Active())
+	}
+	assert.True(t, len(tracker.Active()[0].Path) <= 2)
+	assert.True(t, f.anyPlausible(8, tracker.Active()))
+}
+
+func TestObjectFilterForgetsEndedTracks(t *testing.T) {
+	config := DefaultMotionConfig()
+	config.ObjectMinDisplacement = 5
+	config.ObjectDisplacementFrames = 4
+	f := newObjectFilter(&config)
+
+	f.follow(1, []*Track{makeTrack(1, 0, 0, 4, 4)})
+	assert.Len(t, f.recent, 1)
+	f.follow(10, nil)
+	assert.Empty(t, f.recent)
+}
+
+func TestObjectFilterIgnoresTracksNotSeenInFrame(t *testing.T) {
+	config := DefaultMotionConfig()
+	config.ObjectMinArea = 4
+	f := newObjectFilter(&config)
+
+	assert.False(t, f.anyPlausible(2, []*Track{makeTrack(1, 0, 0, 4, 4)}))
+	assert.Equal(t, Rejections{}, f.rejections)
+}
@@ -0,0 +1,128 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+// Region describes a group of connected pixels which changed in a frame.
+// The bounds are inclusive and temperatures are raw Lepton values
+// taken from the original (unfloored) frame.
+type Region struct {
+	Left      int
+	Top       int
+	Right     int
+	Bottom    int
+	CentroidX float64
+	CentroidY float64
+	Area      int
+	PeakTemp  uint16
+	MeanTemp  float64
+}
+
+// Width returns the width of the region's bounding box in pixels.
+func (r *Region) Width() int {
+	return r.Right - r.Left + 1
+}
+
+// Height returns the height of the region's bounding box in pixels.
+func (r *Region) Height() int {
+	return r.Bottom - r.Top + 1
+}
+
+type pixelMask [lepton3.FrameRows][lepton3.FrameCols]bool
+
+type point struct {
+	x, y int
+}
+
+// regionFinder groups changed pixels into 8-connected regions.  The
+// stack used for the flood fill is kept between frames to avoid
+// allocating on every frame.
+type regionFinder struct {
+	visited pixelMask
+	stack   []point
+	regions []Region
+}
+
+// find returns the regions formed by the pixels set in changed, limited
+// to the rectangle [start, colStop) x [start, rowStop).  Note: the
+// returned slice will be rewritten next time find is called.
+func (rf *regionFinder) find(changed *pixelMask, frame *lepton3.Frame, start, rowStop, colStop int) []Region {
+	rf.regions = rf.regions[:0]
+	for y := start; y < rowStop; y++ {
+		for x := start; x < colStop; x++ {
+			rf.visited[y][x] = false
+		}
+	}
+
+	for y := start; y < rowStop; y++ {
+		for x := start; x < colStop; x++ {
+			if changed[y][x] && !rf.visited[y][x] {
+				rf.regions = append(rf.regions, rf.fill(changed, frame, x, y, start, rowStop, colStop))
+			}
+		}
+	}
+	return rf.regions
+}
+
+func (rf *regionFinder) fill(changed *pixelMask, frame *lepton3.Frame, x, y, start, rowStop, colStop int) Region {
+	r := Region{Left: x, Top: y, Right: x, Bottom: y}
+	var sumX, sumY, sumTemp int
+
+	rf.visited[y][x] = true
+	rf.stack = append(rf.stack[:0], point{x, y})
+	for len(rf.stack) > 0 {
+		p := rf.stack[len(rf.stack)-1]
+		rf.stack = rf.stack[:len(rf.stack)-1]
+
+		temp := frame.Pix[p.y][p.x]
+		r.Area++
+		sumX += p.x
+		sumY += p.y
+		sumTemp += int(temp)
+		if temp > r.PeakTemp {
+			r.PeakTemp = temp
+		}
+		r.Left = min(r.Left, p.x)
+		r.Right = max(r.Right, p.x)
+		r.Top = min(r.Top, p.y)
+		r.Bottom = max(r.Bottom, p.y)
+
+		for ny := max(p.y-1, start); ny <= min(p.y+1, rowStop-1); ny++ {
+			for nx := max(p.x-1, start); nx <= min(p.x+1, colStop-1); nx++ {
+				if changed[ny][nx] && !rf.visited[ny][nx] {
+					rf.visited[ny][nx] = true
+					rf.stack = append(rf.stack, point{nx, ny})
+				}
+			}
+		}
+	}
+
+	r.CentroidX = float64(sumX) / float64(r.Area)
+	r.CentroidY = float64(sumY) / float64(r.Area)
+	r.MeanTemp = float64(sumTemp) / float64(r.Area)
+	return r
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
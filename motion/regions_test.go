@@ -0,0 +1,89 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func regionTestDetector() *motionDetector {
+	config := defaultMotionParams()
+	config.UseOneDiffOnly = true
+	config.FrameCompareGap = 1
+	config.CountThresh = 1
+	return NewMotionDetector(config)
+}
+
+func TestNoRegionsWithoutMotion(t *testing.T) {
+	detector := regionTestDetector()
+
+	newFrameGen(detector).NoMovement(4)
+	assert.Empty(t, detector.Regions())
+}
+
+func TestFindsSeparateRegions(t *testing.T) {
+	detector := regionTestDetector()
+	gen := newFrameGen(detector)
+	gen.NoMovement(2)
+
+	frame := gen.setupFrame(3300)
+	for y := 10; y <= 12; y++ {
+		for x := 20; x <= 23; x++ {
+			frame.Pix[y][x] = 3500
+		}
+	}
+	frame.Pix[11][21] = 3600
+	frame.Pix[40][60] = 3400
+	frame.Pix[41][61] = 3400
+
+	movement, pixels := detector.pixelsChanged(frame)
+	assert.True(t, movement)
+	assert.Equal(t, 14, pixels)
+
+	regions := detector.Regions()
+	require.Len(t, regions, 2)
+
+	assert.Equal(t, Region{
+		Left:      20,
+		Top:       10,
+		Right:     23,
+		Bottom:    12,
+		CentroidX: 21.5,
+		CentroidY: 11,
+		Area:      12,
+		PeakTemp:  3600,
+		MeanTemp:  (11*3500 + 3600) / 12.0,
+	}, regions[0])
+	assert.Equal(t, 4, regions[0].Width())
+	assert.Equal(t, 3, regions[0].Height())
+
+	// Diagonal neighbours belong to the same region.
+	assert.Equal(t, Region{
+		Left:      60,
+		Top:       40,
+		Right:     61,
+		Bottom:    41,
+		CentroidX: 60.5,
+		CentroidY: 40.5,
+		Area:      2,
+		PeakTemp:  3400,
+		MeanTemp:  3400,
+	}, regions[1])
+}
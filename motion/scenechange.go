@@ -0,0 +1,72 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"fmt"
+)
+
+// What to do when most of the scene changes at once.
+const (
+	SceneChangeSuppress = "suppress"
+	SceneChangeSubtract = "subtract"
+)
+
+func validateSceneChange(conf *MotionConfig) error {
+	if conf.SceneChangeFraction < 0 || conf.SceneChangeFraction > 1 {
+		return fmt.Errorf("scene-change-fraction must be between 0 and 1")
+	}
+	if conf.SceneChangeFraction == 0 {
+		return nil
+	}
+	switch conf.SceneChangeAction {
+	case SceneChangeSuppress, SceneChangeSubtract:
+		return nil
+	default:
+		return fmt.Errorf("scene-change-action must be %q or %q", SceneChangeSuppress, SceneChangeSubtract)
+	}
+}
+
+// sceneChange recognises when too much of the frame has changed for it to
+// be an animal, eg when the sun comes out or a cloud passes.
+type sceneChange struct {
+	maxPixels int
+	subtract  bool
+}
+
+// newSceneChange returns nil if scene change detection is turned off.
+func newSceneChange(conf *MotionConfig, mask *Mask, start, rowStop, colStop int) *sceneChange {
+	if conf.SceneChangeFraction == 0 {
+		return nil
+	}
+	var area int
+	for y := start; y < rowStop; y++ {
+		for x := start; x < colStop; x++ {
+			if !mask.excluded[y][x] {
+				area++
+			}
+		}
+	}
+	return &sceneChange{
+		maxPixels: int(conf.SceneChangeFraction * float64(area)),
+		subtract:  conf.SceneChangeAction == SceneChangeSubtract,
+	}
+}
+
+func (s *sceneChange) isGlobal(changedPixels int) bool {
+	return changedPixels > s.maxPixels
+}
@@ -0,0 +1,89 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSceneChangeDisabledByDefault(t *testing.T) {
+	config := DefaultMotionConfig()
+	assert.Nil(t, newSceneChange(&config, NewMask(nil), 1, 119, 159))
+}
+
+func TestSceneChangeIgnoresMaskedPixels(t *testing.T) {
+	config := DefaultMotionConfig()
+	config.SceneChangeFraction = 0.5
+	mask := NewMask([]MaskConfig{{Name: "left", Shape: Shape{Rect: []int{0, 0, 9, 9}}}})
+
+	s := newSceneChange(&config, mask, 0, 10, 20)
+	assert.False(t, s.isGlobal(50))
+	assert.True(t, s.isGlobal(51))
+}
+
+func TestSceneChangeValidation(t *testing.T) {
+	config := DefaultMotionConfig()
+	assert.NoError(t, validateSceneChange(&config))
+
+	config.SceneChangeFraction = 0.5
+	config.SceneChangeAction = "ignore"
+	assert.EqualError(t, validateSceneChange(&config), `scene-change-action must be "suppress" or "subtract"`)
+
+	config.SceneChangeAction = SceneChangeSubtract
+	config.SceneChangeFraction = 1.5
+	assert.EqualError(t, validateSceneChange(&config), "scene-change-fraction must be between 0 and 1")
+}
+
+func TestBackgroundSceneChangeSuppressed(t *testing.T) {
+	config := defaultMotionParams()
+	config.SceneChangeFraction = 0.5
+	config.SceneChangeAction = SceneChangeSuppress
+	detector := NewBackgroundDetector(config)
+	gen := newFrameGen(nil)
+
+	for i := 0; i < 5; i++ {
+		detector.Detect(gen.setupFrame(3300))
+	}
+	result := detector.Detect(gen.setupFrame(3500))
+	assert.False(t, result.Motion)
+	assert.True(t, result.SceneChange)
+}
+
+func TestBackgroundSceneChangeSubtracted(t *testing.T) {
+	config := defaultMotionParams()
+	config.CountThresh = 4
+	config.BackgroundSigma = 4
+	config.SceneChangeFraction = 0.5
+	config.SceneChangeAction = SceneChangeSubtract
+	detector := NewBackgroundDetector(config)
+	gen := newFrameGen(nil)
+
+	for i := 0; i < 5; i++ {
+		detector.Detect(gen.setupFrame(3300))
+	}
+	// The shift is removed so the warm object stands out.
+	result := detector.Detect(spotFrame(gen, 3500, 30, 40, 200))
+	assert.False(t, result.SceneChange)
+	assert.True(t, result.Motion)
+	assert.Equal(t, 9, result.ChangedPixels)
+
+	result = detector.Detect(gen.setupFrame(3500))
+	assert.False(t, result.SceneChange)
+	assert.False(t, result.Motion)
+}
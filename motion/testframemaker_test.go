@@ -29,15 +29,17 @@ type TestFrameMaker struct {
 	BackgroundVal      int
 	BrightSpotVal      int
 	brightSpotPosition int
+	BrightSpotSize     int
 	now                time.Duration
 }
 
 func MakeTestFrameMaker(motionProcessor *MotionProcessor) *TestFrameMaker {
 	return &TestFrameMaker{
-		processor:     motionProcessor,
-		BackgroundVal: 3300,
-		BrightSpotVal: 100,
-		now:           time.Minute,
+		processor:      motionProcessor,
+		BackgroundVal:  3300,
+		BrightSpotVal:  100,
+		BrightSpotSize: 3,
+		now:            time.Minute,
 	}
 }
 
@@ -85,15 +87,11 @@ func (tfm *TestFrameMaker) makeFrameWithBrightSport(position int) *lepton3.Frame
 
 	brightness16 := uint16(tfm.BackgroundVal + tfm.BrightSpotVal)
 
-	frame.Pix[position][position] = brightness16
-	frame.Pix[position+1][position] = brightness16
-	frame.Pix[position+2][position] = brightness16
-	frame.Pix[position][position+1] = brightness16
-	frame.Pix[position+1][position+1] = brightness16
-	frame.Pix[position+2][position+1] = brightness16
-	frame.Pix[position][position+2] = brightness16
-	frame.Pix[position+1][position+2] = brightness16
-	frame.Pix[position+2][position+2] = brightness16
+	for y := position; y < position+tfm.BrightSpotSize; y++ {
+		for x := position; x < position+tfm.BrightSpotSize; x++ {
+			frame.Pix[y][x] = brightness16
+		}
+	}
 
 	return frame
 }
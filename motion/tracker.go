@@ -0,0 +1,162 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"math"
+	"sort"
+)
+
+// TrackPoint is the position of a tracked object in a single frame.
+type TrackPoint struct {
+	Frame int
+	X     float64
+	Y     float64
+}
+
+// Track follows a single object across frames.  Velocity is measured in
+// pixels per frame.  EndFrame is the last frame the object was seen in and
+// Region is where it was seen.  Path holds at least the most recent
+// maxPath positions, older ones are dropped so that long lived tracks
+// don't keep growing.
+type Track struct {
+	ID         int
+	StartFrame int
+	EndFrame   int
+	Path       []TrackPoint
+	VelocityX  float64
+	VelocityY  float64
+	Region     Region
+	missed     int
+	maxPath    int
+	rejected   bool
+}
+
+// Last returns the most recent position of the track.
+func (t *Track) Last() TrackPoint {
+	return t.Path[len(t.Path)-1]
+}
+
+func (t *Track) predict(frame int) (float64, float64) {
+	last := t.Last()
+	gap := float64(frame - last.Frame)
+	return last.X + t.VelocityX*gap, last.Y + t.VelocityY*gap
+}
+
+func (t *Track) add(frame int, r *Region) {
+	if len(t.Path) > 0 {
+		last := t.Last()
+		gap := float64(frame - last.Frame)
+		t.VelocityX = (r.CentroidX - last.X) / gap
+		t.VelocityY = (r.CentroidY - last.Y) / gap
+	}
+	if t.maxPath > 0 && len(t.Path) >= 2*t.maxPath {
+		// Trimming in batches avoids copying the path on every frame.
+		t.Path = append(t.Path[:0], t.Path[len(t.Path)-t.maxPath+1:]...)
+	}
+	t.Path = append(t.Path, TrackPoint{Frame: frame, X: r.CentroidX, Y: r.CentroidY})
+	t.EndFrame = frame
+	t.Region = *r
+	t.missed = 0
+}
+
+// NewTracker returns a tracker which keeps up to maxPath positions for
+// each track, or all of them if maxPath is 0.
+func NewTracker(maxDistance float64, maxMissedFrames, maxPath int) *Tracker {
+	return &Tracker{
+		maxDistance:     maxDistance,
+		maxMissedFrames: maxMissedFrames,
+		maxPath:         maxPath,
+	}
+}
+
+// Tracker associates regions between frames using the nearest predicted
+// track position so that each object keeps the same track ID for as long
+// as it is visible.  A track is ended once it hasn't been matched for more
+// than maxMissedFrames frames.
+type Tracker struct {
+	maxDistance     float64
+	maxMissedFrames int
+	maxPath         int
+	nextID          int
+	active          []*Track
+}
+
+type trackMatch struct {
+	track    int
+	region   int
+	distance float64
+}
+
+// Update matches the regions found in a frame to the active tracks.  It
+// returns the tracks which started and ended as a result.
+func (tr *Tracker) Update(frame int, regions []Region) (started, ended []*Track) {
+	var matches []trackMatch
+	for ti, track := range tr.active {
+		px, py := track.predict(frame)
+		for ri := range regions {
+			dist := math.Hypot(regions[ri].CentroidX-px, regions[ri].CentroidY-py)
+			if dist <= tr.maxDistance {
+				matches = append(matches, trackMatch{ti, ri, dist})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+
+	trackMatched := make([]bool, len(tr.active))
+	regionMatched := make([]bool, len(regions))
+	for _, m := range matches {
+		if trackMatched[m.track] || regionMatched[m.region] {
+			continue
+		}
+		trackMatched[m.track] = true
+		regionMatched[m.region] = true
+		tr.active[m.track].add(frame, &regions[m.region])
+	}
+
+	stillActive := tr.active[:0]
+	for ti, track := range tr.active {
+		if !trackMatched[ti] {
+			track.missed++
+			if track.missed > tr.maxMissedFrames {
+				ended = append(ended, track)
+				continue
+			}
+		}
+		stillActive = append(stillActive, track)
+	}
+	tr.active = stillActive
+
+	for ri := range regions {
+		if !regionMatched[ri] {
+			tr.nextID++
+			track := &Track{ID: tr.nextID, StartFrame: frame, maxPath: tr.maxPath}
+			track.add(frame, &regions[ri])
+			tr.active = append(tr.active, track)
+			started = append(started, track)
+		}
+	}
+	return started, ended
+}
+
+// Active returns the tracks which are currently being followed.
+// Note: The returned tracks will continue to be updated by the tracker.
+func (tr *Tracker) Active() []*Track {
+	return tr.active
+}
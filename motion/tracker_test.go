@@ -0,0 +1,97 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func regionAt(x, y float64) Region {
+	return Region{CentroidX: x, CentroidY: y, Area: 9}
+}
+
+func TestTrackerKeepsIDsForCrossingObjects(t *testing.T) {
+	tracker := NewTracker(10, 2, 20)
+
+	started, _ := tracker.Update(1, []Region{regionAt(10, 50), regionAt(90, 54)})
+	require.Len(t, started, 2)
+	leftID, rightID := started[0].ID, started[1].ID
+	assert.NotEqual(t, leftID, rightID)
+
+	// The objects move towards each other and pass.
+	for frame := 2; frame <= 16; frame++ {
+		step := float64(frame-1) * 6
+		started, ended := tracker.Update(frame, []Region{regionAt(90-step, 54), regionAt(10+step, 50)})
+		assert.Empty(t, started)
+		assert.Empty(t, ended)
+	}
+
+	tracks := tracker.Active()
+	require.Len(t, tracks, 2)
+	assert.Equal(t, leftID, tracks[0].ID)
+	assert.Equal(t, 100.0, tracks[0].Last().X)
+	assert.Equal(t, 6.0, tracks[0].VelocityX)
+	assert.Equal(t, rightID, tracks[1].ID)
+	assert.Equal(t, 0.0, tracks[1].Last().X)
+	assert.Equal(t, -6.0, tracks[1].VelocityX)
+	assert.Len(t, tracks[0].Path, 16)
+}
+
+func TestTrackerEndsTrackAfterMissedFrames(t *testing.T) {
+	tracker := NewTracker(10, 2, 20)
+
+	tracker.Update(1, []Region{regionAt(10, 10)})
+	tracker.Update(2, []Region{regionAt(12, 10)})
+
+	_, ended := tracker.Update(3, nil)
+	assert.Empty(t, ended)
+	_, ended = tracker.Update(4, nil)
+	assert.Empty(t, ended)
+	_, ended = tracker.Update(5, nil)
+	require.Len(t, ended, 1)
+	assert.Equal(t, 1, ended[0].StartFrame)
+	assert.Equal(t, 2, ended[0].EndFrame)
+	assert.Empty(t, tracker.Active())
+}
+
+func TestTrackerStartsNewTrackForDistantRegion(t *testing.T) {
+	tracker := NewTracker(10, 2, 20)
+
+	tracker.Update(1, []Region{regionAt(10, 10)})
+	started, _ := tracker.Update(2, []Region{regionAt(40, 10)})
+	require.Len(t, started, 1)
+	assert.Equal(t, 2, started[0].ID)
+	assert.Len(t, tracker.Active(), 2)
+}
+
+func TestTrackPathIsBounded(t *testing.T) {
+	tracker := NewTracker(10, 2, 5)
+
+	for frame := 1; frame <= 1000; frame++ {
+		tracker.Update(frame, []Region{regionAt(float64(frame%2), 10)})
+	}
+
+	tracks := tracker.Active()
+	require.Len(t, tracks, 1)
+	path := tracks[0].Path
+	assert.True(t, len(path) >= 5 && len(path) <= 10, "path has %d points", len(path))
+	assert.Equal(t, 1000, path[len(path)-1].Frame)
+	assert.Equal(t, 996, path[len(path)-5].Frame)
+}
@@ -0,0 +1,58 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+// triggerVotes counts how many of the most recent frames had motion
+// detected.  Unlike counting consecutive frames, a frame without motion
+// doesn't discard the frames with motion before it, so objects which
+// flicker in and out of detection can still trigger a recording.
+type triggerVotes struct {
+	window []bool
+	next   int
+	count  int
+}
+
+// newTriggerVotes returns nil if size is 0 so the caller can fall back
+// to counting consecutive frames.
+func newTriggerVotes(size int) *triggerVotes {
+	if size <= 0 {
+		return nil
+	}
+	return &triggerVotes{window: make([]bool, size)}
+}
+
+// add records whether the latest frame had motion and returns the number
+// of frames in the window which had motion.
+func (tv *triggerVotes) add(motion bool) int {
+	if tv.window[tv.next] {
+		tv.count--
+	}
+	tv.window[tv.next] = motion
+	if motion {
+		tv.count++
+	}
+	tv.next = (tv.next + 1) % len(tv.window)
+	return tv.count
+}
+
+func (tv *triggerVotes) reset() {
+	for i := range tv.window {
+		tv.window[i] = false
+	}
+	tv.next = 0
+	tv.count = 0
+}
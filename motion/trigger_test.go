@@ -0,0 +1,44 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTriggerVotesDisabled(t *testing.T) {
+	assert.Nil(t, newTriggerVotes(0))
+}
+
+func TestTriggerVotesCountsWindow(t *testing.T) {
+	tv := newTriggerVotes(3)
+	var counts []int
+	for _, motion := range []bool{true, false, true, true, false, false, false} {
+		counts = append(counts, tv.add(motion))
+	}
+	assert.Equal(t, []int{1, 1, 2, 2, 2, 1, 0}, counts)
+}
+
+func TestTriggerVotesReset(t *testing.T) {
+	tv := newTriggerVotes(3)
+	tv.add(true)
+	tv.add(true)
+	tv.reset()
+	assert.Equal(t, 1, tv.add(true))
+}
@@ -0,0 +1,139 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+// The zone index is stored per pixel in a byte and zone 0 is used for
+// pixels outside all configured zones.
+const maxZones = 254
+
+// ZoneConfig gives an area of the frame its own motion thresholds.  If
+// DeltaThresh or CountThresh aren't set the global values are used.
+type ZoneConfig struct {
+	Name        string `yaml:"name"`
+	Shape       `yaml:",inline"`
+	DeltaThresh uint16 `yaml:"delta-thresh"`
+	CountThresh int    `yaml:"count-thresh"`
+}
+
+func (z *ZoneConfig) Validate() error {
+	if err := z.Shape.Validate(); err != nil {
+		return fmt.Errorf("zone %q: %v", z.Name, err)
+	}
+	if z.CountThresh < 0 {
+		return fmt.Errorf("zone %q: count-thresh must not be negative", z.Name)
+	}
+	return nil
+}
+
+func validateZones(zones []ZoneConfig) error {
+	if len(zones) > maxZones {
+		return errors.New("too many motion zones")
+	}
+	for i := range zones {
+		if err := zones[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type zone struct {
+	name         string
+	deltaThresh  uint16
+	countThresh  int
+	count        int
+	defaultDelta bool
+}
+
+// zoneMap records which zone each pixel belongs to and counts the changed
+// pixels in each zone.  Where zones overlap, the first one listed wins.
+type zoneMap struct {
+	zoneOf [lepton3.FrameRows][lepton3.FrameCols]uint8
+	zones  []zone
+}
+
+func newZoneMap(conf MotionConfig) zoneMap {
+	zm := zoneMap{
+		zones: []zone{{name: "default", deltaThresh: conf.DeltaThresh, countThresh: conf.CountThresh, defaultDelta: true}},
+	}
+	for _, zc := range conf.Zones {
+		z := zone{name: zc.Name, deltaThresh: zc.DeltaThresh, countThresh: zc.CountThresh}
+		if z.deltaThresh == 0 {
+			z.deltaThresh = conf.DeltaThresh
+			z.defaultDelta = true
+		}
+		if z.countThresh == 0 {
+			z.countThresh = conf.CountThresh
+		}
+		zm.zones = append(zm.zones, z)
+	}
+
+	for y := 0; y < lepton3.FrameRows; y++ {
+		for x := 0; x < lepton3.FrameCols; x++ {
+			for i := range conf.Zones {
+				if conf.Zones[i].Contains(x, y) {
+					zm.zoneOf[y][x] = uint8(i + 1)
+					break
+				}
+			}
+		}
+	}
+	return zm
+}
+
+func (zm *zoneMap) at(x, y int) *zone {
+	return &zm.zones[zm.zoneOf[y][x]]
+}
+
+// defaultDeltaThresh returns the delta threshold used outside of zones.
+func (zm *zoneMap) defaultDeltaThresh() uint16 {
+	return zm.zones[0].deltaThresh
+}
+
+// setDefaultDeltaThresh changes the delta threshold for all zones which
+// don't have their own.
+func (zm *zoneMap) setDefaultDeltaThresh(delta uint16) {
+	for i := range zm.zones {
+		if zm.zones[i].defaultDelta {
+			zm.zones[i].deltaThresh = delta
+		}
+	}
+}
+
+func (zm *zoneMap) resetCounts() {
+	for i := range zm.zones {
+		zm.zones[i].count = 0
+	}
+}
+
+// triggered returns true if any zone has enough changed pixels.
+func (zm *zoneMap) triggered() bool {
+	for i := range zm.zones {
+		z := &zm.zones[i]
+		if z.count >= z.countThresh {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package motion
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZoneWithHigherCountThreshIgnoresSmallMovement(t *testing.T) {
+	config := defaultMotionParams()
+	config.UseOneDiffOnly = true
+	config.Zones = []ZoneConfig{
+		{Name: "near", Shape: Shape{Rect: []int{0, 0, 40, 40}}, CountThresh: 20},
+	}
+	detector := NewMotionDetector(config)
+
+	detects, pixels := newFrameGen(detector).Movement(5)
+	assertAllFalse(t, detects)
+	assert.Equal(t, []int{0, 9, 9, 9, 18}, pixels)
+}
+
+func TestZoneWithLowerDeltaThreshIsMoreSensitive(t *testing.T) {
+	config := defaultMotionParams()
+	config.UseOneDiffOnly = true
+	config.DeltaThresh = 500
+	detector := NewMotionDetector(config)
+
+	detects, _ := newFrameGen(detector).Movement(5)
+	assertAllFalse(t, detects)
+
+	config.Zones = []ZoneConfig{
+		{Name: "far", Shape: Shape{Rect: []int{0, 0, 40, 40}}, DeltaThresh: 30},
+	}
+	detector = NewMotionDetector(config)
+
+	detects, _ = newFrameGen(detector).Movement(5)
+	assert.Equal(t, []bool{false, true, true, true, true}, detects)
+}
+
+func TestMotionInAnyZoneTriggers(t *testing.T) {
+	config := defaultMotionParams()
+	config.UseOneDiffOnly = true
+	config.CountThresh = 100
+	config.Zones = []ZoneConfig{
+		{Name: "top", Shape: Shape{Rect: []int{0, 0, 159, 5}}},
+		{Name: "spot", Shape: Shape{Rect: []int{0, 6, 159, 40}}, CountThresh: 8},
+	}
+	detector := NewMotionDetector(config)
+
+	detects, _ := newFrameGen(detector).Movement(5)
+	assert.Equal(t, []bool{false, true, true, true, true}, detects)
+}
+
+func TestFirstZoneWinsWhenOverlapping(t *testing.T) {
+	zm := newZoneMap(MotionConfig{
+		DeltaThresh: 50,
+		Zones: []ZoneConfig{
+			{Name: "a", Shape: Shape{Rect: []int{0, 0, 10, 10}}, DeltaThresh: 10},
+			{Name: "b", Shape: Shape{Rect: []int{5, 5, 20, 20}}, DeltaThresh: 20},
+		},
+	})
+	assert.Equal(t, "a", zm.at(7, 7).name)
+	assert.Equal(t, "b", zm.at(15, 15).name)
+	assert.Equal(t, "default", zm.at(30, 30).name)
+	assert.Equal(t, uint16(50), zm.at(30, 30).deltaThresh)
+}
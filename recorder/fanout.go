@@ -0,0 +1,162 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+// FanOutRecorder passes recordings on to several recorders.  A recorder
+// which fails is left out of the rest of the recording so it doesn't
+// affect the others.  Errors are only returned when none of the
+// recorders are working.
+type FanOutRecorder struct {
+	children []Recorder
+	active   []bool
+}
+
+func NewFanOutRecorder(children ...Recorder) *FanOutRecorder {
+	return &FanOutRecorder{
+		children: children,
+		active:   make([]bool, len(children)),
+	}
+}
+
+// CheckCanRecord succeeds if any of the recorders can record.
+func (fr *FanOutRecorder) CheckCanRecord() error {
+	var errs []error
+	for _, child := range fr.children {
+		if err := child.CheckCanRecord(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) < len(fr.children) {
+		return nil
+	}
+	return combineErrors(errs)
+}
+
+// StartRecording starts a recording on every recorder which is able to
+// record.
+func (fr *FanOutRecorder) StartRecording() error {
+	return fr.start(func(child Recorder) error {
+		return child.StartRecording()
+	})
+}
+
+// StartRecordingPart starts the next part of a split recording on every
+// recorder which is able to record.
+func (fr *FanOutRecorder) StartRecordingPart(part Part) error {
+	return fr.start(func(child Recorder) error {
+		return StartRecordingPart(child, part)
+	})
+}
+
+func (fr *FanOutRecorder) start(start func(Recorder) error) error {
+	var errs []error
+	for i, child := range fr.children {
+		fr.active[i] = false
+		// Checking again is cheap and means a recorder is never started
+		// on the strength of an out of date check.
+		err := child.CheckCanRecord()
+		if err == nil {
+			err = start(child)
+		}
+		if err != nil {
+			log.Printf("recorder %d not started: %v", i, err)
+			errs = append(errs, err)
+			continue
+		}
+		fr.active[i] = true
+	}
+	if fr.anyActive() {
+		return nil
+	}
+	return combineErrors(errs)
+}
+
+func (fr *FanOutRecorder) StopRecording() error {
+	var errs []error
+	for i, child := range fr.children {
+		if !fr.active[i] {
+			continue
+		}
+		fr.active[i] = false
+		if err := child.StopRecording(); err != nil {
+			log.Printf("recorder %d failed to stop: %v", i, err)
+			errs = append(errs, err)
+		}
+	}
+	return combineErrors(errs)
+}
+
+func (fr *FanOutRecorder) WriteFrame(frame *lepton3.Frame) error {
+	return fr.write(func(child Recorder) error {
+		return child.WriteFrame(frame)
+	})
+}
+
+func (fr *FanOutRecorder) WriteFrameWithMetadata(frame *lepton3.Frame, metadata FrameMetadata) error {
+	return fr.write(func(child Recorder) error {
+		return WriteFrame(child, frame, metadata)
+	})
+}
+
+// write writes to the active recorders.  A recorder which fails to write
+// is stopped so that whatever it has recorded so far is kept.
+func (fr *FanOutRecorder) write(writeTo func(Recorder) error) error {
+	var errs []error
+	for i, child := range fr.children {
+		if !fr.active[i] {
+			continue
+		}
+		if err := writeTo(child); err != nil {
+			log.Printf("recorder %d failed to write frame, stopping it: %v", i, err)
+			errs = append(errs, err)
+			fr.active[i] = false
+			if err := child.StopRecording(); err != nil {
+				log.Printf("recorder %d failed to stop: %v", i, err)
+			}
+		}
+	}
+	if fr.anyActive() {
+		return nil
+	}
+	return combineErrors(errs)
+}
+
+func (fr *FanOutRecorder) anyActive() bool {
+	for _, active := range fr.active {
+		if active {
+			return true
+		}
+	}
+	return false
+}
+
+func combineErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return fmt.Errorf("%d recorders failed: %v", len(errs), errs)
+}
@@ -0,0 +1,154 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRecorder struct {
+	checkErr error
+	startErr error
+	writeErr error
+	started  int
+	stopped  int
+	writes   int
+}
+
+func (r *fakeRecorder) CheckCanRecord() error { return r.checkErr }
+
+func (r *fakeRecorder) StartRecording() error {
+	if r.startErr != nil {
+		return r.startErr
+	}
+	r.started++
+	return nil
+}
+
+func (r *fakeRecorder) StopRecording() error {
+	r.stopped++
+	return nil
+}
+
+func (r *fakeRecorder) WriteFrame(*lepton3.Frame) error {
+	if r.writeErr != nil {
+		return r.writeErr
+	}
+	r.writes++
+	return nil
+}
+
+func recordFrames(fr *FanOutRecorder, frames int) error {
+	if err := fr.StartRecording(); err != nil {
+		return err
+	}
+	for i := 0; i < frames; i++ {
+		if err := fr.WriteFrame(new(lepton3.Frame)); err != nil {
+			return err
+		}
+	}
+	return fr.StopRecording()
+}
+
+func TestFanOutWritesToAll(t *testing.T) {
+	a, b := new(fakeRecorder), new(fakeRecorder)
+	fr := NewFanOutRecorder(a, b)
+
+	assert.NoError(t, recordFrames(fr, 3))
+	for _, r := range []*fakeRecorder{a, b} {
+		assert.Equal(t, 1, r.started)
+		assert.Equal(t, 3, r.writes)
+		assert.Equal(t, 1, r.stopped)
+	}
+}
+
+func TestFanOutSkipsRecorderWhichCantRecord(t *testing.T) {
+	a, b := new(fakeRecorder), &fakeRecorder{checkErr: errors.New("disk full")}
+	fr := NewFanOutRecorder(a, b)
+
+	assert.NoError(t, fr.CheckCanRecord())
+	assert.NoError(t, recordFrames(fr, 3))
+	assert.Equal(t, 3, a.writes)
+	assert.Equal(t, 0, b.started)
+	assert.Equal(t, 0, b.stopped)
+}
+
+func TestFanOutStopsRecorderWhichFailsToWrite(t *testing.T) {
+	a, b := new(fakeRecorder), &fakeRecorder{writeErr: errors.New("broken pipe")}
+	fr := NewFanOutRecorder(a, b)
+
+	assert.NoError(t, recordFrames(fr, 3))
+	assert.Equal(t, 3, a.writes)
+	assert.Equal(t, 1, b.stopped)
+}
+
+func TestFanOutFailsWhenAllRecordersFail(t *testing.T) {
+	a := &fakeRecorder{checkErr: errors.New("disk full")}
+	b := &fakeRecorder{startErr: errors.New("no network")}
+	fr := NewFanOutRecorder(a, b)
+
+	assert.NoError(t, fr.CheckCanRecord())
+	assert.EqualError(t, fr.StartRecording(), "2 recorders failed: [disk full no network]")
+
+	b.checkErr = errors.New("no network")
+	assert.EqualError(t, fr.CheckCanRecord(), "2 recorders failed: [disk full no network]")
+}
+
+func TestFanOutPassesOnMetadata(t *testing.T) {
+	a := new(metadataCountingRecorder)
+	b := new(fakeRecorder)
+	fr := NewFanOutRecorder(a, b)
+
+	fr.StartRecording()
+	assert.NoError(t, WriteFrame(fr, new(lepton3.Frame), FrameMetadata{Score: 2}))
+	assert.Equal(t, []FrameMetadata{{Score: 2}}, a.metadata)
+	assert.Equal(t, 1, b.writes)
+}
+
+func TestFanOutPassesOnPart(t *testing.T) {
+	withParts := new(partCountingRecorder)
+	without := new(fakeRecorder)
+	fr := NewFanOutRecorder(withParts, without)
+
+	part := Part{SessionID: "abc", Number: 3}
+	assert.NoError(t, fr.StartRecordingPart(part))
+	assert.Equal(t, []Part{part}, withParts.parts)
+	assert.Equal(t, 1, without.started)
+}
+
+func TestFanOutChecksWhenStarting(t *testing.T) {
+	a, b := new(fakeRecorder), new(fakeRecorder)
+	b.checkErr = errors.New("disk full")
+	fr := NewFanOutRecorder(a, b)
+
+	assert.NoError(t, fr.CheckCanRecord())
+	assert.NoError(t, recordFrames(fr, 1))
+	assert.Equal(t, 1, a.started)
+	assert.Equal(t, 0, b.started)
+
+	// An earlier failed check doesn't stop a recorder which has since
+	// recovered.
+	assert.NoError(t, fr.CheckCanRecord())
+	b.checkErr = nil
+	assert.NoError(t, recordFrames(fr, 1))
+	assert.Equal(t, 2, a.started)
+	assert.Equal(t, 1, b.started)
+}
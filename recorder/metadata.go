@@ -0,0 +1,156 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/TheCacophonyProject/lepton3"
+)
+
+// Why a frame was treated the way it was by motion detection.
+const (
+	TriggerStart       = "start"
+	TriggerMotion      = "motion"
+	TriggerContinue    = "continue"
+	TriggerResume      = "resume"
+	TriggerSceneChange = "scene-change"
+	TriggerRejected    = "rejected"
+)
+
+// FrameMetadata is what motion detection found in a recorded frame.
+// Trigger is empty if the frame didn't have any motion.  Time is when
+// the frame was received from the camera.
+type FrameMetadata struct {
+	Time          time.Time `json:"time"`
+	Score         float64   `json:"score"`
+	ChangedPixels int       `json:"changed-pixels"`
+	Regions       []Region  `json:"regions"`
+	Trigger       string    `json:"trigger,omitempty"`
+}
+
+// Region is a group of connected pixels which changed.  The bounds are
+// inclusive.
+type Region struct {
+	Left      int     `json:"left"`
+	Top       int     `json:"top"`
+	Right     int     `json:"right"`
+	Bottom    int     `json:"bottom"`
+	CentroidX float64 `json:"centroid-x"`
+	CentroidY float64 `json:"centroid-y"`
+	Area      int     `json:"area"`
+	PeakTemp  uint16  `json:"peak-temp"`
+	MeanTemp  float64 `json:"mean-temp"`
+}
+
+// Telemetry is the camera's telemetry for a recorded frame.
+type Telemetry struct {
+	TimeOnMs      int64   `json:"time-on-ms"`
+	FFCState      string  `json:"ffc-state"`
+	FrameCount    int     `json:"frame-count"`
+	FrameMean     uint16  `json:"frame-mean"`
+	TempC         float64 `json:"temp-c"`
+	LastFFCTempC  float64 `json:"last-ffc-temp-c"`
+	LastFFCTimeMs int64   `json:"last-ffc-time-ms"`
+}
+
+func newTelemetry(t *lepton3.Telemetry) Telemetry {
+	return Telemetry{
+		TimeOnMs:      int64(t.TimeOn / time.Millisecond),
+		FFCState:      t.FFCState,
+		FrameCount:    t.FrameCount,
+		FrameMean:     t.FrameMean,
+		TempC:         t.TempC,
+		LastFFCTempC:  t.LastFFCTempC,
+		LastFFCTimeMs: int64(t.LastFFCTime / time.Millisecond),
+	}
+}
+
+// RecordedFrame is the metadata saved for each frame in a recording.
+type RecordedFrame struct {
+	FrameMetadata
+	Telemetry Telemetry `json:"telemetry"`
+}
+
+// MetadataRecorder is implemented by recorders which can store metadata
+// alongside the frames they record.
+type MetadataRecorder interface {
+	Recorder
+	WriteFrameWithMetadata(*lepton3.Frame, FrameMetadata) error
+}
+
+// WriteFrame writes frame to r, along with its metadata if r can store
+// it.
+func WriteFrame(r Recorder, frame *lepton3.Frame, metadata FrameMetadata) error {
+	if mr, ok := r.(MetadataRecorder); ok {
+		return mr.WriteFrameWithMetadata(frame, metadata)
+	}
+	return r.WriteFrame(frame)
+}
+
+// Location is where the camera is.  Latitude and longitude are in
+// degrees and altitude and accuracy are in metres.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+	Accuracy  float64 `json:"accuracy,omitempty"`
+}
+
+// RecordingMetadata collects the metadata for the frames in a recording.
+// MotionConfig holds the motion detection settings as YAML and Part
+// links the files of a split recording.  Recovered recordings were
+// salvaged after the recorder stopped unexpectedly so their frame
+// metadata has been lost.
+type RecordingMetadata struct {
+	Part
+	Recovered    bool            `json:"recovered,omitempty"`
+	Location     *Location       `json:"location,omitempty"`
+	MotionConfig string          `json:"motion-config"`
+	MaxScore     float64         `json:"max-score"`
+	MeanScore    float64         `json:"mean-score"`
+	Frames       []RecordedFrame `json:"frames"`
+}
+
+// Add records the metadata for the next frame in the recording.
+func (m *RecordingMetadata) Add(frame *lepton3.Frame, metadata FrameMetadata) {
+	if len(m.Frames) == 0 || metadata.Score > m.MaxScore {
+		m.MaxScore = metadata.Score
+	}
+	m.MeanScore += (metadata.Score - m.MeanScore) / float64(len(m.Frames)+1)
+	m.Frames = append(m.Frames, RecordedFrame{
+		FrameMetadata: metadata,
+		Telemetry:     newTelemetry(&frame.Status),
+	})
+}
+
+// WriteFile saves the metadata as JSON.  It is written to a temporary
+// file first so a partially written file is never seen.
+func (m *RecordingMetadata) WriteFile(filename string) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tempName := filename + ".temp"
+	if err := ioutil.WriteFile(tempName, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempName, filename)
+}
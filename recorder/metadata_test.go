@@ -0,0 +1,89 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheCacophonyProject/lepton3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type metadataCountingRecorder struct {
+	NoWriteRecorder
+	metadata []FrameMetadata
+}
+
+func (r *metadataCountingRecorder) WriteFrameWithMetadata(frame *lepton3.Frame, metadata FrameMetadata) error {
+	r.metadata = append(r.metadata, metadata)
+	return nil
+}
+
+func TestWriteFramePassesMetadataWhenSupported(t *testing.T) {
+	r := new(metadataCountingRecorder)
+	require.NoError(t, WriteFrame(r, new(lepton3.Frame), FrameMetadata{Score: 3}))
+	assert.Equal(t, []FrameMetadata{{Score: 3}}, r.metadata)
+
+	assert.NoError(t, WriteFrame(new(NoWriteRecorder), new(lepton3.Frame), FrameMetadata{Score: 3}))
+}
+
+func TestRecordingMetadataSummary(t *testing.T) {
+	m := new(RecordingMetadata)
+	for _, score := range []float64{2, 10, 0, 4} {
+		m.Add(new(lepton3.Frame), FrameMetadata{Score: score})
+	}
+	assert.Equal(t, 10.0, m.MaxScore)
+	assert.Equal(t, 4.0, m.MeanScore)
+	assert.Len(t, m.Frames, 4)
+}
+
+func TestRecordingMetadataWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "recording.json")
+
+	frame := new(lepton3.Frame)
+	frame.Status.TimeOn = 90 * time.Second
+	frame.Status.FFCState = lepton3.FFCComplete
+	frame.Status.TempC = 24.5
+	m := new(RecordingMetadata)
+	m.Add(frame, FrameMetadata{
+		Score:         5,
+		ChangedPixels: 5,
+		Regions:       []Region{{Left: 1, Top: 2, Right: 3, Bottom: 2, Area: 5}},
+		Trigger:       TriggerStart,
+	})
+	require.NoError(t, m.WriteFile(filename))
+
+	buf, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	var loaded RecordingMetadata
+	require.NoError(t, json.Unmarshal(buf, &loaded))
+	assert.Equal(t, *m, loaded)
+	assert.Equal(t, int64(90000), loaded.Frames[0].Telemetry.TimeOnMs)
+	assert.Contains(t, string(buf), `"trigger":"start"`)
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.temp"))
+	assert.Empty(t, matches)
+}
@@ -0,0 +1,61 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Part identifies one file of a recording which was split because it went
+// on for longer than max-secs.  Every part of a recording has the same
+// session ID and parts are numbered from 1.  The zero Part is used when
+// recordings aren't split.
+type Part struct {
+	SessionID string `json:"session-id,omitempty"`
+	Number    int    `json:"part,omitempty"`
+}
+
+// NewPart returns the first part of a new recording session.
+func NewPart() (Part, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Part{}, err
+	}
+	return Part{SessionID: hex.EncodeToString(id), Number: 1}, nil
+}
+
+// Next returns the part following p in the same session.
+func (p Part) Next() Part {
+	return Part{SessionID: p.SessionID, Number: p.Number + 1}
+}
+
+// PartRecorder is implemented by recorders which can label the files of
+// a split recording.
+type PartRecorder interface {
+	Recorder
+	StartRecordingPart(Part) error
+}
+
+// StartRecordingPart starts recording part on r, labelling it if r
+// supports that.
+func StartRecordingPart(r Recorder, part Part) error {
+	if pr, ok := r.(PartRecorder); ok {
+		return pr.StartRecordingPart(part)
+	}
+	return r.StartRecording()
+}
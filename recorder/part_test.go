@@ -0,0 +1,68 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type partCountingRecorder struct {
+	NoWriteRecorder
+	parts []Part
+}
+
+func (r *partCountingRecorder) StartRecordingPart(part Part) error {
+	r.parts = append(r.parts, part)
+	return nil
+}
+
+func TestNewPartsHaveDifferentSessions(t *testing.T) {
+	a, err := NewPart()
+	require.NoError(t, err)
+	b, err := NewPart()
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, a.Number)
+	assert.Len(t, a.SessionID, 16)
+	assert.NotEqual(t, a.SessionID, b.SessionID)
+}
+
+func TestNextPartKeepsSession(t *testing.T) {
+	first, err := NewPart()
+	require.NoError(t, err)
+
+	second := first.Next()
+	assert.Equal(t, first.SessionID, second.SessionID)
+	assert.Equal(t, 2, second.Number)
+	assert.Equal(t, 3, second.Next().Number)
+}
+
+func TestStartRecordingPartPassesPart(t *testing.T) {
+	r := new(partCountingRecorder)
+	part := Part{SessionID: "abc", Number: 2}
+	require.NoError(t, StartRecordingPart(r, part))
+	assert.Equal(t, []Part{part}, r.parts)
+}
+
+func TestStartRecordingPartWithoutPartSupport(t *testing.T) {
+	r := new(fakeRecorder)
+	assert.NoError(t, StartRecordingPart(r, Part{SessionID: "abc", Number: 1}))
+	assert.Equal(t, 1, r.started)
+}
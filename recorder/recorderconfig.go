@@ -18,16 +18,34 @@ package recorder
 
 import (
 	"errors"
-
-	"github.com/TheCacophonyProject/window"
 )
 
 type RecorderConfig struct {
-	MinSecs     int              `yaml:"min-secs"`
-	MaxSecs     int              `yaml:"max-secs"`
-	PreviewSecs int              `yaml:"preview-secs"`
-	WindowStart window.TimeOfDay `yaml:"window-start"`
-	WindowEnd   window.TimeOfDay `yaml:"window-end"`
+	MinSecs             int             `yaml:"min-secs"`
+	MaxSecs             int             `yaml:"max-secs"`
+	PreviewSecs         int             `yaml:"preview-secs"`
+	MergeGapSecs        int             `yaml:"merge-gap-secs"`
+	SplitLongRecordings bool            `yaml:"split-long-recordings"`
+	WindowStart         WindowTime      `yaml:"window-start"`
+	WindowEnd           WindowTime      `yaml:"window-end"`
+	Schedule            []ScheduleEntry `yaml:"schedule"`
+	Latitude            float64         `yaml:"latitude"`
+	Longitude           float64         `yaml:"longitude"`
+	Altitude            float64         `yaml:"altitude"`
+	Accuracy            float64         `yaml:"accuracy"`
+}
+
+// Location returns where the camera is, or nil if that isn't configured.
+func (conf *RecorderConfig) Location() *Location {
+	if conf.Latitude == 0 && conf.Longitude == 0 {
+		return nil
+	}
+	return &Location{
+		Latitude:  conf.Latitude,
+		Longitude: conf.Longitude,
+		Altitude:  conf.Altitude,
+		Accuracy:  conf.Accuracy,
+	}
 }
 
 func DefaultRecorderConfig() RecorderConfig {
@@ -42,11 +60,36 @@ func (conf *RecorderConfig) Validate() error {
 	if conf.MaxSecs < conf.MinSecs {
 		return errors.New("max-secs should be larger than min-secs")
 	}
+	if conf.MergeGapSecs < 0 {
+		return errors.New("merge-gap-secs can not be negative")
+	}
 	if conf.WindowStart.IsZero() && !conf.WindowEnd.IsZero() {
 		return errors.New("window-end is set but window-start isn't")
 	}
 	if !conf.WindowStart.IsZero() && conf.WindowEnd.IsZero() {
 		return errors.New("window-start is set but window-end isn't")
 	}
+	if len(conf.Schedule) > 0 && !conf.WindowStart.IsZero() {
+		return errors.New("schedule can't be used with window-start and window-end")
+	}
+	solar := conf.WindowStart.IsSolar() || conf.WindowEnd.IsSolar()
+	for _, entry := range conf.Schedule {
+		if err := entry.validate(); err != nil {
+			return err
+		}
+		solar = solar || entry.isSolar()
+	}
+	if conf.Latitude < -90 || conf.Latitude > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if conf.Longitude < -180 || conf.Longitude > 180 {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	if conf.Accuracy < 0 {
+		return errors.New("accuracy can not be negative")
+	}
+	if solar && conf.Latitude == 0 && conf.Longitude == 0 {
+		return errors.New("latitude and longitude are needed for windows relative to sunrise or sunset")
+	}
 	return nil
 }
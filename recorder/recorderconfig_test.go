@@ -19,20 +19,19 @@ package recorder
 import (
 	"testing"
 
-	"github.com/TheCacophonyProject/window"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestWindowStartWithoutEndDoesntValidate(t *testing.T) {
 	conf := RecorderConfig{
-		WindowStart: *window.NewTimeOfDay("09:10"),
+		WindowStart: *NewWindowTime("09:10"),
 	}
 	assert.EqualError(t, conf.Validate(), "window-start is set but window-end isn't")
 }
 
 func TestWindowEndWithoutStartDoesntValidate(t *testing.T) {
 	conf := RecorderConfig{
-		WindowEnd: *window.NewTimeOfDay("09:10"),
+		WindowEnd: *NewWindowTime("09:10"),
 	}
 	assert.EqualError(t, conf.Validate(), "window-end is set but window-start isn't")
 }
@@ -44,3 +43,65 @@ func TestMinSecsGreaterThanMaxSecsDoesntValidate(t *testing.T) {
 	}
 	assert.EqualError(t, conf.Validate(), "max-secs should be larger than min-secs")
 }
+
+func TestNegativeMergeGapDoesntValidate(t *testing.T) {
+	conf := RecorderConfig{
+		MergeGapSecs: -1,
+	}
+	assert.EqualError(t, conf.Validate(), "merge-gap-secs can not be negative")
+}
+
+func TestScheduleWithWindowDoesntValidate(t *testing.T) {
+	conf := RecorderConfig{
+		WindowStart: *NewWindowTime("09:10"),
+		WindowEnd:   *NewWindowTime("10:10"),
+		Schedule:    []ScheduleEntry{{Days: []string{"mon"}}},
+	}
+	assert.EqualError(t, conf.Validate(), "schedule can't be used with window-start and window-end")
+}
+
+func TestScheduleWithUnknownDayDoesntValidate(t *testing.T) {
+	conf := RecorderConfig{
+		Schedule: []ScheduleEntry{{Days: []string{"someday"}}},
+	}
+	assert.EqualError(t, conf.Validate(), `unknown schedule day "someday"`)
+}
+
+func TestScheduleEntryWithoutEndDoesntValidate(t *testing.T) {
+	conf := RecorderConfig{
+		Schedule: []ScheduleEntry{{Start: *NewWindowTime("09:10")}},
+	}
+	assert.EqualError(t, conf.Validate(), "schedule entry needs both start and end")
+}
+
+func TestSolarWindowWithoutLocationDoesntValidate(t *testing.T) {
+	conf := RecorderConfig{
+		WindowStart: *NewWindowTime("sunset"),
+		WindowEnd:   *NewWindowTime("sunrise"),
+	}
+	assert.EqualError(t, conf.Validate(), "latitude and longitude are needed for windows relative to sunrise or sunset")
+}
+
+func TestInvalidLatitudeDoesntValidate(t *testing.T) {
+	conf := RecorderConfig{
+		Latitude: 91,
+	}
+	assert.EqualError(t, conf.Validate(), "latitude must be between -90 and 90")
+}
+
+func TestNegativeAccuracyDoesntValidate(t *testing.T) {
+	conf := RecorderConfig{
+		Accuracy: -1,
+	}
+	assert.EqualError(t, conf.Validate(), "accuracy can not be negative")
+}
+
+func TestLocation(t *testing.T) {
+	conf := RecorderConfig{}
+	assert.Nil(t, conf.Location())
+
+	conf.Latitude = -43.5
+	conf.Longitude = 172.5
+	conf.Accuracy = 5
+	assert.Equal(t, &Location{Latitude: -43.5, Longitude: 172.5, Accuracy: 5}, conf.Location())
+}
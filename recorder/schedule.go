@@ -0,0 +1,246 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheCacophonyProject/window"
+)
+
+// ScheduleEntry is a recording window which applies on some days of the
+// week.  Days are given as three letter names ("mon", "tue", ...) or as
+// "weekdays" or "weekends" and an entry with no days applies every day.
+// A window which crosses midnight belongs to the day it starts on.  If
+// start and end aren't set then recording is allowed all day.
+type ScheduleEntry struct {
+	Days  []string   `yaml:"days"`
+	Start WindowTime `yaml:"start"`
+	End   WindowTime `yaml:"end"`
+}
+
+var dayNames = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+func (e *ScheduleEntry) validate() error {
+	if e.Start.IsZero() != e.End.IsZero() {
+		return fmt.Errorf("schedule entry needs both start and end")
+	}
+	for _, day := range e.Days {
+		if _, ok := dayNames[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("unknown schedule day %q", day)
+		}
+	}
+	return nil
+}
+
+func (e *ScheduleEntry) isSolar() bool {
+	return e.Start.IsSolar() || e.End.IsSolar()
+}
+
+func (e *ScheduleEntry) days() string {
+	if len(e.Days) == 0 {
+		return "every day"
+	}
+	return strings.Join(e.Days, ",")
+}
+
+func (e *ScheduleEntry) String() string {
+	if e.Start.IsZero() {
+		return e.days() + " all day"
+	}
+	return fmt.Sprintf("%s %s to %s", e.days(), e.Start, e.End)
+}
+
+// NewSchedule creates the recording schedule described by conf.  If no
+// schedule is configured then the window-start and window-end settings
+// are used for every day.
+func NewSchedule(conf *RecorderConfig) *Schedule {
+	entries := conf.Schedule
+	if len(entries) == 0 {
+		entries = []ScheduleEntry{{Start: conf.WindowStart, End: conf.WindowEnd}}
+	}
+	s := &Schedule{
+		Now:       time.Now,
+		entries:   entries,
+		latitude:  conf.Latitude,
+		longitude: conf.Longitude,
+	}
+	for _, entry := range entries {
+		if entry.isSolar() {
+			s.solar = true
+		}
+	}
+	return s
+}
+
+// Schedule decides when recordings can be made.  Recording is allowed
+// when any of the entries is active.  Windows relative to sunrise and
+// sunset are worked out again at the start of each day.  The Now field
+// can be used to override the time source (for testing).
+type Schedule struct {
+	Now       func() time.Time
+	entries   []ScheduleEntry
+	latitude  float64
+	longitude float64
+	solar     bool
+
+	mu      sync.Mutex
+	day     time.Time
+	sunrise time.Time
+	sunset  time.Time
+	windows []scheduledWindow
+}
+
+type scheduledWindow struct {
+	days   [7]bool
+	window *window.Window
+}
+
+func newScheduledWindow(entry ScheduleEntry, sunrise, sunset time.Time, now func() time.Time) scheduledWindow {
+	sw := scheduledWindow{
+		window: window.New(entry.Start.On(sunrise, sunset), entry.End.On(sunrise, sunset)),
+	}
+	sw.window.Now = now
+	if len(entry.Days) == 0 {
+		for day := range sw.days {
+			sw.days[day] = true
+		}
+	}
+	for _, name := range entry.Days {
+		for _, day := range dayNames[strings.ToLower(name)] {
+			sw.days[day] = true
+		}
+	}
+	return sw
+}
+
+func (s *Schedule) now() time.Time {
+	return s.Now()
+}
+
+// Active returns true if recordings are currently allowed.
+func (s *Schedule) Active() bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(now)
+	for _, sw := range s.windows {
+		if sw.active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// update works out the windows for the day of now if it hasn't been done
+// already.
+func (s *Schedule) update(now time.Time) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if s.windows != nil && day.Equal(s.day) {
+		return
+	}
+	s.day = day
+	if s.solar {
+		s.sunrise, s.sunset = SunTimes(day, s.latitude, s.longitude)
+	}
+	s.windows = s.windows[:0]
+	for _, entry := range s.entries {
+		s.windows = append(s.windows, newScheduledWindow(entry, s.sunrise, s.sunset, s.now))
+	}
+	if s.solar {
+		log.Printf("recording windows for %s: %s", day.Format("2006-01-02"), strings.Join(s.today(), "; "))
+	}
+}
+
+func (sw *scheduledWindow) active(now time.Time) bool {
+	if !sw.window.Active() {
+		return false
+	}
+	day := now.Weekday()
+	if sw.window.End.Day() != sw.window.Start.Day() && beforeTimeOfDay(now, sw.window.Start) {
+		// In the part of the window after midnight, so it started the
+		// day before.
+		day = (day + 6) % 7
+	}
+	return sw.days[day]
+}
+
+func beforeTimeOfDay(t, timeOfDay time.Time) bool {
+	if t.Hour() != timeOfDay.Hour() {
+		return t.Hour() < timeOfDay.Hour()
+	}
+	if t.Minute() != timeOfDay.Minute() {
+		return t.Minute() < timeOfDay.Minute()
+	}
+	return t.Second() < timeOfDay.Second()
+}
+
+// Today returns the recording windows for today with any times relative
+// to sunrise and sunset worked out.
+func (s *Schedule) Today() []string {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(now)
+	return s.today()
+}
+
+func (s *Schedule) today() []string {
+	var windows []string
+	for i, entry := range s.entries {
+		if entry.Start.IsZero() {
+			windows = append(windows, entry.String())
+			continue
+		}
+		w := s.windows[i].window
+		windows = append(windows, fmt.Sprintf("%s %02d:%02d to %02d:%02d", entry.days(),
+			w.Start.Hour(), w.Start.Minute(), w.End.Hour(), w.End.Minute()))
+	}
+	return windows
+}
+
+// SunTimes returns today's sunrise and sunset as used by the schedule.
+// They are zero if the schedule doesn't use them.
+func (s *Schedule) SunTimes() (sunrise, sunset time.Time) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(now)
+	return s.sunrise, s.sunset
+}
+
+func (s *Schedule) String() string {
+	var entries []string
+	for _, entry := range s.entries {
+		entries = append(entries, entry.String())
+	}
+	return strings.Join(entries, "; ")
+}
@@ -0,0 +1,135 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 2019-01-05 is a Saturday.
+func at(day int, hour, minute int) func() time.Time {
+	return func() time.Time {
+		return time.Date(2019, 1, day, hour, minute, 0, 0, time.Local)
+	}
+}
+
+func scheduleAt(conf *RecorderConfig, now func() time.Time) *Schedule {
+	s := NewSchedule(conf)
+	s.Now = now
+	return s
+}
+
+func TestScheduleAlwaysActiveByDefault(t *testing.T) {
+	conf := DefaultRecorderConfig()
+	assert.True(t, scheduleAt(&conf, at(5, 12, 0)).Active())
+	assert.Equal(t, "every day all day", NewSchedule(&conf).String())
+}
+
+func TestScheduleUsesWindowWithoutEntries(t *testing.T) {
+	conf := RecorderConfig{
+		WindowStart: *NewWindowTime("17:00"),
+		WindowEnd:   *NewWindowTime("07:00"),
+	}
+	assert.True(t, scheduleAt(&conf, at(5, 18, 0)).Active())
+	assert.True(t, scheduleAt(&conf, at(5, 6, 59)).Active())
+	assert.False(t, scheduleAt(&conf, at(5, 12, 0)).Active())
+}
+
+func TestScheduleMultipleWindows(t *testing.T) {
+	conf := RecorderConfig{
+		Schedule: []ScheduleEntry{
+			{Start: *NewWindowTime("05:00"), End: *NewWindowTime("08:00")},
+			{Start: *NewWindowTime("18:00"), End: *NewWindowTime("21:00")},
+		},
+	}
+	assert.True(t, scheduleAt(&conf, at(7, 6, 0)).Active())
+	assert.True(t, scheduleAt(&conf, at(7, 19, 0)).Active())
+	assert.False(t, scheduleAt(&conf, at(7, 12, 0)).Active())
+	assert.Equal(t, "every day 05:00 to 08:00; every day 18:00 to 21:00", NewSchedule(&conf).String())
+}
+
+func TestScheduleDays(t *testing.T) {
+	conf := RecorderConfig{
+		Schedule: []ScheduleEntry{
+			{Days: []string{"weekdays"}, Start: *NewWindowTime("09:00"), End: *NewWindowTime("17:00")},
+			{Days: []string{"sun"}},
+		},
+	}
+	assert.False(t, scheduleAt(&conf, at(5, 12, 0)).Active()) // Saturday
+	assert.True(t, scheduleAt(&conf, at(6, 3, 0)).Active())   // Sunday
+	assert.True(t, scheduleAt(&conf, at(7, 12, 0)).Active())  // Monday
+	assert.False(t, scheduleAt(&conf, at(7, 18, 0)).Active()) // Monday
+}
+
+func TestScheduleWindowAcrossMidnightBelongsToStartDay(t *testing.T) {
+	conf := RecorderConfig{
+		Schedule: []ScheduleEntry{
+			{Days: []string{"Fri"}, Start: *NewWindowTime("22:00"), End: *NewWindowTime("04:00")},
+		},
+	}
+	assert.True(t, scheduleAt(&conf, at(4, 23, 0)).Active())  // Friday night
+	assert.True(t, scheduleAt(&conf, at(5, 3, 0)).Active())   // Saturday morning
+	assert.False(t, scheduleAt(&conf, at(5, 23, 0)).Active()) // Saturday night
+	assert.False(t, scheduleAt(&conf, at(4, 3, 0)).Active())  // Friday morning
+}
+
+func TestScheduleRelativeToSunriseAndSunset(t *testing.T) {
+	conf := RecorderConfig{
+		WindowStart: *NewWindowTime("sunset-30m"),
+		WindowEnd:   *NewWindowTime("sunrise+30m"),
+		Latitude:    51.5074,
+		Longitude:   -0.1278,
+	}
+	utc := func(day, hour, minute int) func() time.Time {
+		return func() time.Time {
+			return time.Date(2019, 6, day, hour, minute, 0, 0, time.UTC)
+		}
+	}
+	// Sunset is about 20:21 and sunrise about 03:43 (UTC).
+	assert.True(t, scheduleAt(&conf, utc(21, 20, 0)).Active())
+	assert.False(t, scheduleAt(&conf, utc(21, 19, 40)).Active())
+	assert.True(t, scheduleAt(&conf, utc(21, 4, 5)).Active())
+	assert.False(t, scheduleAt(&conf, utc(21, 4, 25)).Active())
+
+	s := scheduleAt(&conf, utc(21, 12, 0))
+	sunrise, sunset := s.SunTimes()
+	assertNear(t, time.Date(2019, 6, 21, 3, 43, 0, 0, time.UTC), sunrise)
+	assertNear(t, time.Date(2019, 6, 21, 20, 21, 0, 0, time.UTC), sunset)
+	assert.Len(t, s.Today(), 1)
+	assert.Equal(t, "every day sunset-30m to sunrise+30m", s.String())
+}
+
+func TestScheduleUpdatedEachDay(t *testing.T) {
+	conf := RecorderConfig{
+		WindowStart: *NewWindowTime("sunset"),
+		WindowEnd:   *NewWindowTime("sunrise"),
+		Latitude:    51.5074,
+		Longitude:   -0.1278,
+	}
+	now := time.Date(2019, 6, 21, 12, 0, 0, 0, time.UTC)
+	s := scheduleAt(&conf, func() time.Time { return now })
+	_, midsummer := s.SunTimes()
+
+	now = time.Date(2019, 12, 21, 12, 0, 0, 0, time.UTC)
+	_, midwinter := s.SunTimes()
+	assert.True(t, midsummer.Hour() > midwinter.Hour())
+	assert.Equal(t, 21, midwinter.Day())
+	assert.Equal(t, time.December, midwinter.Month())
+}
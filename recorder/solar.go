@@ -0,0 +1,61 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"math"
+	"time"
+)
+
+// The zenith angle of the sun at sunrise and sunset, allowing for
+// atmospheric refraction and the size of the sun.
+const sunZenith = 90.833
+
+// SunTimes returns the times of sunrise and sunset on the day of date at
+// the given latitude and longitude (in degrees, north and east positive).
+// The times are in date's time zone.  It uses NOAA's approximate solar
+// position equations which are accurate to within a minute or two.  On
+// days when the sun doesn't rise sunrise and sunset are both at solar
+// noon and on days when it doesn't set they are 12 hours either side of
+// it.
+func SunTimes(date time.Time, latitude, longitude float64) (sunrise, sunset time.Time) {
+	// Fractional year in radians, at midday.
+	gamma := 2 * math.Pi / 365 * float64(date.YearDay()-1)
+
+	// Equation of time in minutes and solar declination in radians.
+	eqTime := 229.18 * (0.000075 + 0.001868*math.Cos(gamma) - 0.032077*math.Sin(gamma) -
+		0.014615*math.Cos(2*gamma) - 0.040849*math.Sin(2*gamma))
+	decl := 0.006918 - 0.399912*math.Cos(gamma) + 0.070257*math.Sin(gamma) -
+		0.006758*math.Cos(2*gamma) + 0.000907*math.Sin(2*gamma) -
+		0.002697*math.Cos(3*gamma) + 0.00148*math.Sin(3*gamma)
+
+	lat := latitude * math.Pi / 180
+	cosHourAngle := math.Cos(sunZenith*math.Pi/180)/(math.Cos(lat)*math.Cos(decl)) -
+		math.Tan(lat)*math.Tan(decl)
+	hourAngle := math.Acos(math.Max(-1, math.Min(1, cosHourAngle))) * 180 / math.Pi
+
+	// Minutes after midnight UTC.
+	noon := 720 - 4*longitude - eqTime
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	sunrise = midnight.Add(minutes(noon - 4*hourAngle)).In(date.Location())
+	sunset = midnight.Add(minutes(noon + 4*hourAngle)).In(date.Location())
+	return sunrise, sunset
+}
+
+func minutes(m float64) time.Duration {
+	return time.Duration(m * float64(time.Minute))
+}
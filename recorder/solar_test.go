@@ -0,0 +1,52 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertNear(t *testing.T, expected, actual time.Time) {
+	assert.WithinDuration(t, expected, actual, 2*time.Minute, "expected %s, got %s", expected, actual)
+}
+
+func TestSunTimesLondonMidsummer(t *testing.T) {
+	sunrise, sunset := SunTimes(time.Date(2019, 6, 21, 12, 0, 0, 0, time.UTC), 51.5074, -0.1278)
+	assertNear(t, time.Date(2019, 6, 21, 3, 43, 0, 0, time.UTC), sunrise)
+	assertNear(t, time.Date(2019, 6, 21, 20, 21, 0, 0, time.UTC), sunset)
+}
+
+func TestSunTimesInLocalTimeZone(t *testing.T) {
+	nz, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Skip("time zone data not available")
+	}
+	// Christchurch has just under 9 hours of daylight in midwinter.
+	sunrise, sunset := SunTimes(time.Date(2019, 6, 21, 0, 0, 0, 0, nz), -43.5321, 172.6362)
+	assert.Equal(t, nz, sunrise.Location())
+	assert.Equal(t, 21, sunrise.Day())
+	assert.Equal(t, 21, sunset.Day())
+	assert.InDelta(t, 8*60+56, sunset.Sub(sunrise).Minutes(), 4)
+}
+
+func TestSunTimesWhenSunDoesntSet(t *testing.T) {
+	sunrise, sunset := SunTimes(time.Date(2019, 6, 21, 12, 0, 0, 0, time.UTC), 80, 0)
+	assert.Equal(t, 24*time.Hour, sunset.Sub(sunrise))
+}
@@ -0,0 +1,141 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TheCacophonyProject/window"
+)
+
+const (
+	Sunrise = "sunrise"
+	Sunset  = "sunset"
+)
+
+const timeOfDayLayout = "15:04"
+
+// WindowTime is the start or end of a recording window.  It is either a
+// time of day such as "17:30" or is relative to sunrise or sunset, such
+// as "sunset-30m" or "sunrise+1h".
+type WindowTime struct {
+	window.TimeOfDay
+	Solar  string
+	Offset time.Duration
+}
+
+// NewWindowTime parses s, returning the zero WindowTime if it isn't
+// valid.
+func NewWindowTime(s string) *WindowTime {
+	wt, err := ParseWindowTime(s)
+	if err != nil {
+		return new(WindowTime)
+	}
+	return &wt
+}
+
+// ParseWindowTime parses a time of day or a time relative to sunrise or
+// sunset.
+func ParseWindowTime(s string) (WindowTime, error) {
+	for _, solar := range []string{Sunrise, Sunset} {
+		if !strings.HasPrefix(s, solar) {
+			continue
+		}
+		wt := WindowTime{Solar: solar}
+		if offset := s[len(solar):]; offset != "" {
+			if offset[0] != '+' && offset[0] != '-' {
+				return WindowTime{}, fmt.Errorf("invalid window time %q", s)
+			}
+			var err error
+			if wt.Offset, err = time.ParseDuration(offset); err != nil {
+				return WindowTime{}, fmt.Errorf("invalid window time %q: %v", s, err)
+			}
+		}
+		return wt, nil
+	}
+
+	if s == "" {
+		return WindowTime{}, nil
+	}
+	t, err := time.Parse(timeOfDayLayout, s)
+	if err != nil {
+		return WindowTime{}, fmt.Errorf("invalid window time %q", s)
+	}
+	return WindowTime{TimeOfDay: window.TimeOfDay{Time: t}}, nil
+}
+
+func (wt *WindowTime) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var val string
+	if err := unmarshal(&val); err != nil {
+		return err
+	}
+	var err error
+	*wt, err = ParseWindowTime(val)
+	return err
+}
+
+// IsZero returns true if the time hasn't been set.
+func (wt WindowTime) IsZero() bool {
+	return wt.Solar == "" && wt.TimeOfDay.IsZero()
+}
+
+// IsSolar returns true if the time is relative to sunrise or sunset.
+func (wt WindowTime) IsSolar() bool {
+	return wt.Solar != ""
+}
+
+// On returns the time on the day of sunrise and sunset.
+func (wt WindowTime) On(sunrise, sunset time.Time) time.Time {
+	switch wt.Solar {
+	case Sunrise:
+		return sunrise.Add(wt.Offset)
+	case Sunset:
+		return sunset.Add(wt.Offset)
+	}
+	return wt.Time
+}
+
+func (wt WindowTime) String() string {
+	if !wt.IsSolar() {
+		return fmt.Sprintf("%02d:%02d", wt.Hour(), wt.Minute())
+	}
+	if wt.Offset == 0 {
+		return wt.Solar
+	}
+	sign := "+"
+	offset := wt.Offset
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return wt.Solar + sign + shortDuration(offset)
+}
+
+// shortDuration formats d without the zero minutes and seconds which
+// time.Duration adds.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
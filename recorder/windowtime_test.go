@@ -0,0 +1,68 @@
+// thermal-recorder - record thermal video footage of warm moving objects
+//  Copyright (C) 2018, The Cacophony Project
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package recorder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestParseWindowTime(t *testing.T) {
+	for _, s := range []string{"17:30", "sunrise", "sunset-30m", "sunrise+1h30m", "sunset+2h"} {
+		wt, err := ParseWindowTime(s)
+		require.NoError(t, err)
+		assert.Equal(t, s, wt.String())
+	}
+
+	wt, err := ParseWindowTime("sunset-45m")
+	require.NoError(t, err)
+	assert.Equal(t, WindowTime{Solar: Sunset, Offset: -45 * time.Minute}, wt)
+	assert.True(t, wt.IsSolar())
+	assert.False(t, wt.IsZero())
+}
+
+func TestParseInvalidWindowTime(t *testing.T) {
+	for _, s := range []string{"25:00", "sunset30m", "sunrise+soon", "noon"} {
+		_, err := ParseWindowTime(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestWindowTimeOn(t *testing.T) {
+	sunrise := time.Date(2019, 1, 5, 6, 0, 0, 0, time.UTC)
+	sunset := time.Date(2019, 1, 5, 21, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, sunset.Add(-30*time.Minute), NewWindowTime("sunset-30m").On(sunrise, sunset))
+	assert.Equal(t, sunrise.Add(time.Hour), NewWindowTime("sunrise+1h").On(sunrise, sunset))
+	assert.Equal(t, 17, NewWindowTime("17:30").On(sunrise, sunset).Hour())
+}
+
+func TestWindowTimeYAML(t *testing.T) {
+	var conf struct {
+		Start WindowTime `yaml:"start"`
+		End   WindowTime `yaml:"end"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte("start: sunset-30m\nend: 07:00\n"), &conf))
+	assert.Equal(t, *NewWindowTime("sunset-30m"), conf.Start)
+	assert.Equal(t, *NewWindowTime("07:00"), conf.End)
+
+	assert.Error(t, yaml.Unmarshal([]byte("start: dusk\n"), &conf))
+}
@@ -17,11 +17,7 @@
 package throttle
 
 import (
-	"encoding/json"
-	"log"
-	"time"
-
-	"github.com/godbus/dbus"
+	"github.com/TheCacophonyProject/thermal-recorder/events"
 )
 
 // uses the event api to record that video was throttled at a particular time.
@@ -29,28 +25,5 @@ type ThrottledEventRecorder struct {
 }
 
 func (er ThrottledEventRecorder) WhenThrottled() {
-	ts := time.Now()
-	eventDetails := map[string]interface{}{
-		"description": map[string]interface{}{
-			"type": "throttle",
-		},
-	}
-	detailsJSON, err := json.Marshal(&eventDetails)
-	if err != nil {
-		log.Printf("Could not record throttle event: %s", err)
-		return
-	}
-
-	conn, err := dbus.SystemBus()
-	if err != nil {
-		log.Printf("Could not record throttle event: %s", err)
-		return
-	}
-
-	obj := conn.Object("org.cacophony.Events", "/org/cacophony/Events")
-	call := obj.Call("org.cacophony.Events.Queue", 0, detailsJSON, ts.UnixNano())
-	if call.Err != nil {
-		log.Printf("Could not record throttle event: %s", call.Err)
-		return
-	}
+	events.Queue("throttle", nil)
 }
@@ -48,6 +48,7 @@ type ThrottledRecorder struct {
 	throttledFrames       uint32
 	frameCount            uint32
 	refillRate            float64
+	session               string
 }
 
 type ThrottledEventListener interface {
@@ -94,6 +95,39 @@ func (throttler *ThrottledRecorder) CheckCanRecord() error {
 }
 
 func (throttler *ThrottledRecorder) StartRecording() error {
+	throttler.session = ""
+	return throttler.start(throttler.recorder.StartRecording)
+}
+
+// StartRecordingPart is throttled in the same way as starting a new
+// recording for the first part.  The later parts carry on a recording
+// which is already under way so they are only throttled frame by frame.
+func (throttler *ThrottledRecorder) StartRecordingPart(part recorder.Part) error {
+	if part.Number > 1 {
+		return throttler.continueRecording(part)
+	}
+	throttler.session = ""
+	err := throttler.start(func() error {
+		return recorder.StartRecordingPart(throttler.recorder, part)
+	})
+	if throttler.recording {
+		throttler.session = part.SessionID
+	}
+	return err
+}
+
+// continueRecording starts the next part of the recording session, unless
+// the session was throttled from the start.
+func (throttler *ThrottledRecorder) continueRecording(part recorder.Part) error {
+	if part.SessionID != throttler.session {
+		throttler.recording = false
+		return nil
+	}
+	throttler.recording = true
+	return recorder.StartRecordingPart(throttler.recorder, part)
+}
+
+func (throttler *ThrottledRecorder) start(start func() error) error {
 	if throttler.sparseBucket.IsFull() {
 		log.Print("Sparse recording starting soon...")
 		throttler.mainBucket.AddTokens(throttler.sparseRecordingLength)
@@ -102,7 +136,7 @@ func (throttler *ThrottledRecorder) StartRecording() error {
 	if throttler.mainBucket.HasTokens(throttler.minRecordingLength) {
 		throttler.recording = true
 		throttler.sparseBucket.Empty()
-		return throttler.recorder.StartRecording()
+		return start()
 	} else {
 		throttler.recording = false
 		log.Print("Recording not started - currently throttled")
@@ -128,12 +162,26 @@ func (throttler *ThrottledRecorder) StopRecording() error {
 }
 
 func (throttler *ThrottledRecorder) WriteFrame(frame *lepton3.Frame) error {
+	return throttler.writeFrame(func() error {
+		return throttler.recorder.WriteFrame(frame)
+	})
+}
+
+// WriteFrameWithMetadata passes the metadata on to the wrapped recorder
+// for the frames which aren't throttled.
+func (throttler *ThrottledRecorder) WriteFrameWithMetadata(frame *lepton3.Frame, metadata recorder.FrameMetadata) error {
+	return throttler.writeFrame(func() error {
+		return recorder.WriteFrame(throttler.recorder, frame, metadata)
+	})
+}
+
+func (throttler *ThrottledRecorder) writeFrame(write func() error) error {
 	throttler.askedToWriteFrame = true
 
 	if throttler.recording {
 		throttler.frameCount++
 		if throttler.mainBucket.HasTokens(1) {
-			return throttler.recorder.WriteFrame(frame)
+			return write()
 		} else {
 			if throttler.throttledFrames == 0 && throttler.listener != nil {
 				log.Printf("Recording throttled.")
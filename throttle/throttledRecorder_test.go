@@ -230,3 +230,86 @@ func TestSendsEventsWhenRecordingDoesntEvenStart(t *testing.T) {
 	PlayRecordingFrames(recorder, 50)
 	assert.Equal(t, 2, throttledRecorder.throttledEvents)
 }
+
+type MetadataRecorder struct {
+	CountWritesRecorder
+	scores []float64
+	parts  []recorder.Part
+}
+
+func (rec *MetadataRecorder) StartRecordingPart(part recorder.Part) error {
+	rec.parts = append(rec.parts, part)
+	return rec.StartRecording()
+}
+
+func (rec *MetadataRecorder) WriteFrameWithMetadata(frame *lepton3.Frame, metadata recorder.FrameMetadata) error {
+	rec.scores = append(rec.scores, metadata.Score)
+	return rec.WriteFrame(frame)
+}
+
+func TestMetadataPassedOnForFramesNotThrottled(t *testing.T) {
+	baseRecorder := new(MetadataRecorder)
+	throttled := NewThrottledRecorder(baseRecorder, nil, DefaultTestThrottleConfig(), 1)
+
+	throttled.NextFrame()
+	throttled.StartRecording()
+	for count := 0; count < THROTTLE_FRAMES+5; count++ {
+		throttled.NextFrame()
+		recorder.WriteFrame(throttled, new(lepton3.Frame), recorder.FrameMetadata{Score: float64(count)})
+	}
+	throttled.StopRecording()
+
+	assert.Equal(t, THROTTLE_FRAMES, baseRecorder.writes)
+	assert.Len(t, baseRecorder.scores, THROTTLE_FRAMES)
+	assert.Equal(t, 0.0, baseRecorder.scores[0])
+}
+
+func TestPartPassedOnWhenNotThrottled(t *testing.T) {
+	baseRecorder := new(MetadataRecorder)
+	throttled := NewThrottledRecorder(baseRecorder, nil, DefaultTestThrottleConfig(), 1)
+
+	part := recorder.Part{SessionID: "abc", Number: 1}
+	throttled.NextFrame()
+	assert.NoError(t, throttled.StartRecordingPart(part))
+	assert.Equal(t, []recorder.Part{part}, baseRecorder.parts)
+}
+
+func TestNextPartStartsWhenBucketIsDrained(t *testing.T) {
+	baseRecorder := new(MetadataRecorder)
+	throttled := NewThrottledRecorder(baseRecorder, nil, DefaultTestThrottleConfig(), 1)
+
+	part := recorder.Part{SessionID: "abc", Number: 1}
+	throttled.NextFrame()
+	assert.NoError(t, throttled.StartRecordingPart(part))
+	for count := 0; count < THROTTLE_FRAMES-MIN_FRAMES_PER_RECORDING/2; count++ {
+		throttled.NextFrame()
+		throttled.WriteFrame(new(lepton3.Frame))
+	}
+	assert.NoError(t, throttled.StopRecording())
+
+	// too few tokens left to start a new recording but the next part
+	// carries on until the bucket is empty
+	assert.NoError(t, throttled.StartRecordingPart(part.Next()))
+	for count := 0; count < MIN_FRAMES_PER_RECORDING; count++ {
+		throttled.NextFrame()
+		throttled.WriteFrame(new(lepton3.Frame))
+	}
+	assert.NoError(t, throttled.StopRecording())
+
+	assert.Equal(t, []recorder.Part{part, part.Next()}, baseRecorder.parts)
+	assert.Equal(t, THROTTLE_FRAMES, baseRecorder.writes)
+}
+
+func TestNextPartNotStartedWhenRecordingWasThrottled(t *testing.T) {
+	baseRecorder := new(MetadataRecorder)
+	throttled := NewThrottledRecorder(baseRecorder, nil, DefaultTestThrottleConfig(), 1)
+	PlayRecordingFrames(throttled, THROTTLE_FRAMES)
+
+	part := recorder.Part{SessionID: "abc", Number: 1}
+	assert.NoError(t, throttled.StartRecordingPart(part))
+	assert.NoError(t, throttled.StopRecording())
+	assert.NoError(t, throttled.StartRecordingPart(part.Next()))
+	assert.NoError(t, throttled.StopRecording())
+
+	assert.Empty(t, baseRecorder.parts)
+}